@@ -0,0 +1,235 @@
+package spacetraders
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How long to wait before checking again if a ship hasn't arrived yet
+	arrivalRecheck = 10 * time.Second
+	// How long to remember flights we've announced
+	arrivalMemory = 7 * 24 * time.Hour
+)
+
+// Keeps track of flights in progress, and publishes ShipArrived once for each,
+// after checking with the API that the ship really arrived.
+type ArrivalWatcher struct {
+	mu sync.Mutex
+	c  *Client
+	// Flights we're waiting for, by ID
+	Pending map[string]*FlightPlan `json:"pending"`
+	// Flights that were announced, and when
+	Notified map[string]time.Time `json:"notified"`
+	timers   map[string]*time.Timer
+}
+
+var arrivals = newArrivalWatcher()
+
+func GetArrivalWatcher() *ArrivalWatcher {
+	return arrivals
+}
+
+func newArrivalWatcher() *ArrivalWatcher {
+	return &ArrivalWatcher{
+		Pending:  make(map[string]*FlightPlan),
+		Notified: make(map[string]time.Time),
+		timers:   make(map[string]*time.Timer),
+	}
+}
+
+// Start checking for arrivals, including flights loaded from a save
+func (w *ArrivalWatcher) Start(c *Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.c = c
+	for id, fp := range w.Pending {
+		w.arm(id, fp.ArrivesAt)
+	}
+}
+
+// Check on a flight at a time. w.mu must be held.
+func (w *ArrivalWatcher) arm(id string, when time.Time) {
+	if w.c == nil {
+		return
+	}
+	if t, ok := w.timers[id]; ok {
+		t.Stop()
+	}
+	w.timers[id] = time.AfterFunc(time.Until(when), func() { w.check(id) })
+}
+
+// Start watching a flight, unless it's already watched or announced. Flights
+// that ended a while ago, e.g. from looking up an old flight plan, are history
+// rather than something to wait for.
+func (w *ArrivalWatcher) track(c *Client, fp FlightPlan) {
+	if !fp.TerminatedAt.IsZero() || time.Since(fp.ArrivesAt) > time.Minute {
+		return
+	}
+	w.watch(c, fp)
+}
+
+// Start watching a flight a ship is still on, however late it is, unless it's
+// already watched or announced.
+func (w *ArrivalWatcher) watch(c *Client, fp FlightPlan) {
+	if fp.ID == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.Notified[fp.ID]; ok {
+		return
+	}
+	if _, ok := w.Pending[fp.ID]; ok {
+		return
+	}
+	if w.c == nil {
+		w.c = c
+	}
+	w.Pending[fp.ID] = &fp
+	w.arm(fp.ID, fp.ArrivesAt)
+}
+
+// The flights being watched, soonest first
+func (w *ArrivalWatcher) Flights() []FlightPlan {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var res []FlightPlan
+	for _, fp := range w.Pending {
+		res = append(res, *fp)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ArrivesAt.Before(res[j].ArrivesAt) })
+	return res
+}
+
+// A flight being watched, by ID
+func (w *ArrivalWatcher) Flight(id string) (FlightPlan, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fp, ok := w.Pending[id]
+	if !ok {
+		return FlightPlan{}, false
+	}
+	return *fp, true
+}
+
+// Whether a flight's arrival was already announced
+func (w *ArrivalWatcher) announced(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.Notified[id]
+	return ok
+}
+
+// Ask the API whether a flight's ship has arrived, and announce it if it has
+func (w *ArrivalWatcher) check(id string) {
+	w.mu.Lock()
+	fp, ok := w.Pending[id]
+	c := w.c
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ships, err := c.MyShips()
+	if err != nil {
+		log.Printf("Can't check on %s: %v", fp.ShortID, err)
+		w.mu.Lock()
+		w.arm(id, time.Now().Add(arrivalRecheck))
+		w.mu.Unlock()
+		return
+	}
+	for i, s := range ships {
+		if s.ID != fp.ShipID {
+			continue
+		}
+		if s.FlightPlanID == fp.ID {
+			log.Printf("%s hasn't arrived yet, checking again in %s", fp.ShortID, arrivalRecheck)
+			w.mu.Lock()
+			w.arm(id, time.Now().Add(arrivalRecheck))
+			w.mu.Unlock()
+			return
+		}
+		w.arrived(Event{
+			Type:     ShipArrived,
+			ShipID:   s.ID,
+			Ship:     s.ShortID,
+			Location: s.LocationName,
+			Cargo:    ships[i].Cargo,
+			Flight:   fp,
+		})
+		return
+	}
+
+	log.Printf("Ship %s for %s is gone, not watching it", fp.ShipID, fp.ShortID)
+	w.mu.Lock()
+	delete(w.Pending, id)
+	delete(w.timers, id)
+	w.mu.Unlock()
+}
+
+// Publish an arrival, unless it was already announced
+func (w *ArrivalWatcher) arrived(e Event) {
+	if e.Flight == nil {
+		events.Publish(e)
+		return
+	}
+
+	id := e.Flight.ID
+	w.mu.Lock()
+	if _, ok := w.Notified[id]; ok {
+		w.mu.Unlock()
+		return
+	}
+	w.Notified[id] = time.Now()
+	if fp, ok := w.Pending[id]; ok {
+		// Keep the details from when the flight was created
+		e.Flight = fp
+		delete(w.Pending, id)
+	}
+	if t, ok := w.timers[id]; ok {
+		t.Stop()
+		delete(w.timers, id)
+	}
+	for f, t := range w.Notified {
+		if time.Since(t) > arrivalMemory {
+			delete(w.Notified, f)
+		}
+	}
+	w.mu.Unlock()
+
+	events.Publish(e)
+}
+
+func (w *ArrivalWatcher) Save() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, err := json.Marshal(w)
+	if err != nil {
+		log.Printf("Can't save arrivals: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+func (w *ArrivalWatcher) Load(data string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := json.Unmarshal([]byte(data), w); err != nil {
+		return fmt.Errorf("error decoding arrivals: %v", err)
+	}
+	if w.Pending == nil {
+		w.Pending = make(map[string]*FlightPlan)
+	}
+	if w.Notified == nil {
+		w.Notified = make(map[string]time.Time)
+	}
+	for id, fp := range w.Pending {
+		w.arm(id, fp.ArrivesAt)
+	}
+	return nil
+}
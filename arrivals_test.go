@@ -0,0 +1,158 @@
+package spacetraders
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestArrivalWatcher(t *testing.T) {
+	w := newArrivalWatcher()
+	fp := FlightPlan{ID: "flight-1", ShortID: "f-1", ShipID: "arrivals-ship", Destination: "OE-PM", ArrivesAt: time.Now().Add(time.Hour)}
+	old := FlightPlan{ID: "flight-0", ShipID: "arrivals-ship", ArrivesAt: time.Now().Add(-time.Hour)}
+	w.track(nil, fp)
+	w.track(nil, fp)
+	w.track(nil, old)
+
+	var got []string
+	for _, f := range w.Flights() {
+		got = append(got, f.ID)
+	}
+	if diff := cmp.Diff([]string{"flight-1"}, got); diff != "" {
+		t.Errorf("bad flights: -want +got\n%s", diff)
+	}
+
+	published := func() int {
+		n := 0
+		for _, e := range GetEventBus().Recent(ShipArrived) {
+			if e.ShipID == "arrivals-ship" {
+				n++
+				if e.Flight.Destination != "OE-PM" {
+					t.Errorf("arrival lost the flight details: %+v", e.Flight)
+				}
+			}
+		}
+		return n
+	}
+	arrival := Event{Type: ShipArrived, ShipID: "arrivals-ship", Location: "OE-PM", Flight: &FlightPlan{ID: "flight-1"}}
+	w.arrived(arrival)
+	w.arrived(arrival)
+	if n := published(); n != 1 {
+		t.Errorf("want 1 arrival published, got %d", n)
+	}
+	if fs := w.Flights(); len(fs) != 0 {
+		t.Errorf("still watching %v after arrival", fs)
+	}
+
+	// A restart shouldn't announce the flight again
+	restored := newArrivalWatcher()
+	if err := restored.Load(w.Save()); err != nil {
+		t.Fatalf("can't load arrivals: %v", err)
+	}
+	restored.track(nil, fp)
+	restored.arrived(arrival)
+	if n := published(); n != 1 {
+		t.Errorf("want 1 arrival published after restart, got %d", n)
+	}
+	if fs := restored.Flights(); len(fs) != 0 {
+		t.Errorf("watching %v after restart", fs)
+	}
+}
+
+func TestWatchShipsInFlight(t *testing.T) {
+	saved := arrivals
+	arrivals = newArrivalWatcher()
+	defer func() {
+		arrivals.mu.Lock()
+		for _, t := range arrivals.timers {
+			t.Stop()
+		}
+		arrivals.mu.Unlock()
+		arrivals = saved
+	}()
+
+	// Ships that were already in flight when we started, one of them overdue.
+	// The overdue ship has docked by the time it's checked on.
+	flights := map[string]FlightPlan{
+		"startup-flight": {ID: "startup-flight", ShipID: "startup-ship", Destination: "OE-PM", ArrivesAt: time.Now().Add(time.Hour)},
+		"overdue-flight": {ID: "overdue-flight", ShipID: "overdue-ship", Destination: "OE-NY", ArrivesAt: time.Now().Add(-time.Hour)},
+	}
+	var mu sync.Mutex
+	listings, lookups := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var res interface{}
+		switch {
+		case r.URL.Path == "/my/ships":
+			overdue := Ship{ID: "overdue-ship", LocationName: "OE-NY"}
+			if listings == 0 {
+				overdue = Ship{ID: "overdue-ship", FlightPlanID: "overdue-flight"}
+			}
+			listings++
+			res = MyShipsRes{Ships: []Ship{{ID: "startup-ship", FlightPlanID: "startup-flight"}, overdue}}
+		case strings.HasPrefix(r.URL.Path, "/my/flight-plans/"):
+			lookups++
+			res = FlightPlanRes{FlightPlan: flights[strings.TrimPrefix(r.URL.Path, "/my/flight-plans/")]}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+	c := New()
+	c.server = srv.URL
+
+	arrived := make(chan Event, 10)
+	unsub := GetEventBus().Subscribe(func(e Event) {
+		if e.ShipID == "overdue-ship" {
+			arrived <- e
+		}
+	}, ShipArrived)
+	defer unsub()
+
+	ships, err := c.MyShips()
+	if err != nil {
+		t.Fatalf("can't list ships: %v", err)
+	}
+	var dests []string
+	for _, s := range ships {
+		dests = append(dests, s.FlightPlanDest)
+	}
+	if diff := cmp.Diff([]string{"OE-PM", "OE-NY"}, dests); diff != "" {
+		t.Errorf("bad destinations: -want +got\n%s", diff)
+	}
+
+	select {
+	case e := <-arrived:
+		if e.Flight == nil || e.Flight.ID != "overdue-flight" || e.Location != "OE-NY" {
+			t.Errorf("bad arrival: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("arrival of the overdue ship wasn't published")
+	}
+	var got []string
+	for _, f := range arrivals.Flights() {
+		got = append(got, f.ID)
+	}
+	if diff := cmp.Diff([]string{"startup-flight"}, got); diff != "" {
+		t.Errorf("bad flights: -want +got\n%s", diff)
+	}
+
+	// Watched flights aren't looked up again
+	if _, err := c.MyShips(); err != nil {
+		t.Fatalf("can't list ships again: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if lookups != 2 {
+		t.Errorf("want 2 flight plan lookups, got %d", lookups)
+	}
+}
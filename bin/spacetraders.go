@@ -19,27 +19,20 @@ var (
 	logFile     = flag.String("logfile", "/tmp/spacetraders.log", "Where should the log file be saved")
 	errorsFatal = flag.Bool("errors_fatal", false, "If false, API errors are caught")
 	saveFile    = flag.String("savefile", "spacetraders.save", "What is the file to use as the default save")
+	fuelTable   = flag.String("fuel_table", "", "If not empty, load the fuel model table from this JSON file")
 )
 
 func loop(c *spacetraders.Client) {
 	t := tui.GetUI()
 	for line := range t.GetLine() {
-		cmd, args, err := cli.ParseLine(c, line)
-		if err != nil {
-			cli.ErrMsg(err.Error())
-			continue
-		}
-
-		if err := cmd.Do(c, args); err != nil {
+		if err := cli.RunLine(c, line); err != nil {
 			if err == cli.ErrExit {
 				break
 			}
-			cli.ErrMsg("Error: %v", err)
 			if *errorsFatal {
 				log.Fatal(err)
 			}
 		}
-		cli.Out("")
 	}
 	cli.Out("")
 }
@@ -65,20 +58,15 @@ func runTQ(c *spacetraders.Client) chan (bool) {
 		log.Print("TaskQueue goroutine starting...")
 		for {
 			select {
-			case <-time.After(time.Second):
+			case <-tq.Next():
 				msgs, err := tq.ProcessTasks()
 				if err != nil {
 					cli.Warn("Error processing background tasks: %v", err)
 				}
-				for _, m := range msgs {
-					cli.Out(m)
-				}
-				if len(msgs) > 0 {
-					cli.Out("")
-				}
-			case <-quitTQ:
+				cli.Notify(msgs)
+			case <-q:
 				log.Print("TaskQueue goroutine ended.")
-				break
+				return
 			}
 		}
 	}(quitTQ)
@@ -127,6 +115,8 @@ func createTasks() {
 		return strings.Join(msg, "\n")
 	})
 
+	t.SetView("tasks", cli.TaskSummary)
+
 	tq.Add("updateShips", "", time.Now(), time.Minute, func(c *spacetraders.Client) error {
 		_, err := c.MyShips()
 		if err != nil {
@@ -134,9 +124,12 @@ func createTasks() {
 		}
 		return nil
 	})
+	t.Watch(spacetraders.GetEventBus())
 
-	tq.Add("processRoutes", "", time.Now().Add(10*time.Second), 20*time.Second, func(c *spacetraders.Client) error {
-	  return cli.ProcessRoutes(c)
+	// Ships on routes are processed when their flights arrive, this just
+	// catches any that were missed.
+	tq.Add("processRoutes", "", time.Now().Add(10*time.Second), 5*time.Minute, func(c *spacetraders.Client) error {
+		return cli.ProcessRoutes(c)
 	})
 }
 
@@ -185,6 +178,12 @@ func main() {
 		}
 	}
 
+	if *fuelTable != "" {
+		if err := spacetraders.GetFuelModel().LoadTable(*fuelTable); err != nil {
+			log.Fatalf("Can't load fuel table: %v", err)
+		}
+	}
+
 	t := tui.GetUI()
 	defer t.Close()
 
@@ -194,9 +193,12 @@ func main() {
 
 	cli.SetTUI(t)
 	runUI()
-	quitTQ := runTQ(c)
 	createTasks()
+	// Load before processing tasks, so saved tasks that are already due don't
+	// run before everything they need is loaded.
 	autoLoad()
+	spacetraders.GetArrivalWatcher().Start(c)
+	quitTQ := runTQ(c)
 	loop(c)
 	quitTQ <- true
 	log.Print("Exiting CLI.\n\n")
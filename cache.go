@@ -5,19 +5,23 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
+	shortMu    sync.Mutex // protects the maps of shorts
 	shortToID  = make(map[string]string)
 	idToShort  = make(map[string]string)
 	shortIndex = make(map[CacheKey]int)
 )
 
 type Cache struct {
-	data   map[CacheKey]*CacheItem
-	object map[CacheObjKey][]interface{}
-	update map[CacheKey]func() error
+	mu      sync.Mutex
+	data    map[CacheKey]*CacheItem
+	object  map[CacheObjKey][]interface{}
+	updated map[CacheObjKey]time.Time
+	update  map[CacheKey]func() error
 }
 type CacheKey string
 type CacheObjKey string
@@ -55,9 +59,10 @@ func init() {
 		cargos.data = append(cargos.data, c)
 	}
 	c = &Cache{
-		data:   map[CacheKey]*CacheItem{CARGO: cargos},
-		object: make(map[CacheObjKey][]interface{}),
-		update: make(map[CacheKey]func() error),
+		data:    map[CacheKey]*CacheItem{CARGO: cargos},
+		object:  make(map[CacheObjKey][]interface{}),
+		updated: make(map[CacheObjKey]time.Time),
+		update:  make(map[CacheKey]func() error),
 	}
 }
 
@@ -67,12 +72,16 @@ func GetCache() *Cache {
 
 // Define a new type, and how to update it
 func (c *Cache) RegisterUpdate(key CacheKey, f func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.update[key] = f
 }
 
 // Add a new value to a key, create a short if needed
 func (c *Cache) Add(key CacheKey, data string) {
 	short := makeShort(key, data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if _, ok := c.data[key]; !ok {
 		c.data[key] = &CacheItem{data: []string{}, shorts: []string{}}
 	}
@@ -88,9 +97,11 @@ func (c *Cache) Add(key CacheKey, data string) {
 func (c *Cache) Extend(key CacheKey, data []string, shorts []string) {
 	sort.Strings(data)
 	sort.Strings(shorts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	item, ok := c.data[key]
 	if !ok {
-		c.Store(key, data, shorts)
+		c.data[key] = &CacheItem{expiresOn: time.Now().Add(time.Hour), data: data, shorts: shorts}
 		return
 	}
 
@@ -120,16 +131,20 @@ func (c *Cache) Extend(key CacheKey, data []string, shorts []string) {
 func (c *Cache) Store(key CacheKey, data []string, shorts []string) {
 	sort.Strings(data)
 	sort.Strings(shorts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.data[key] = &CacheItem{expiresOn: time.Now().Add(time.Hour), data: data, shorts: shorts}
 }
 
 // Get the current cached value for a key
 func (c *Cache) Restore(key CacheKey) []string {
+	c.mu.Lock()
 	cached, ok := c.data[key]
+	f, canUpdate := c.update[key]
+	c.mu.Unlock()
 	if !ok || cached.expiresOn.Before(time.Now()) {
 		log.Printf("Cache miss: %q", key)
-		f, ok := c.update[key]
-		if !ok {
+		if !canUpdate {
 			log.Printf("Don't know how to update cache for %q", key)
 			return []string{}
 		}
@@ -137,7 +152,9 @@ func (c *Cache) Restore(key CacheKey) []string {
 			log.Printf("Error caching %q: %v", key, err)
 			return []string{}
 		}
+		c.mu.Lock()
 		cached = c.data[key]
+		c.mu.Unlock()
 	} else {
 		log.Printf("Cache hit: %q", key)
 	}
@@ -149,21 +166,38 @@ func (c *Cache) Restore(key CacheKey) []string {
 
 // Clears cached objects
 func (c *Cache) ClearObjs(key CacheObjKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	delete(c.object, key)
+	delete(c.updated, key)
 }
 
 // Store an arbitrary list of objects
 func (c *Cache) StoreObjs(key CacheObjKey, data []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.object[key] = data
+	c.updated[key] = time.Now()
 }
 
 // Get an arbitrary list of objects from the cache
 func (c *Cache) RestoreObjs(key CacheObjKey) []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.object[key]
 }
 
+// When a list of objects was last stored, or the zero time if it wasn't
+func (c *Cache) ObjsUpdated(key CacheObjKey) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updated[key]
+}
+
 // Create a short name for a given identifier, per type
 func makeShort(key CacheKey, data string) string {
+	shortMu.Lock()
+	defer shortMu.Unlock()
 	short, ok := idToShort[data]
 	if ok {
 		return short
@@ -195,6 +229,8 @@ func makeShort(key CacheKey, data string) string {
 
 // Get the identifier a short is associated with
 func makeLong(id string) string {
+	shortMu.Lock()
+	defer shortMu.Unlock()
 	if long, ok := shortToID[id]; ok {
 		return long
 	}
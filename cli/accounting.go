@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+// How many trades to keep per route. The balance still includes older ones.
+const maxTrades = 10000
+
+// Trades made to reposition a ship that wasn't where the route expected it
+const offRoute = -1
+
+// A single order executed by a route. Leg is the leg the trade pays for: fuel
+// and cargo bought at stop i count towards leg i, and cargo sold at stop i
+// was carried on leg i-1.
+type trade struct {
+	Time  time.Time
+	Ship  string
+	Leg   int
+	Good  string
+	Qty   int
+	Price int
+	Total int
+	Buy   bool
+}
+
+// The effect of the trade on our credits
+func (t *trade) Value() int {
+	if t.Buy {
+		return -t.Total
+	}
+	return t.Total
+}
+
+// Record an order made on behalf of the route
+func (r *route) Record(ship *spacetraders.Ship, leg int, bs bsType, o *spacetraders.Order) {
+	t := trade{
+		Time:  time.Now(),
+		Ship:  ship.ID,
+		Leg:   leg,
+		Good:  o.Good,
+		Qty:   o.Quantity,
+		Price: o.PricePerUnit,
+		Total: o.Total,
+		Buy:   bs == bsBuy,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Trades = append(r.Trades, t)
+	if len(r.Trades) > maxTrades {
+		r.Trades = r.Trades[len(r.Trades)-maxTrades:]
+	}
+	r.Balance += t.Value()
+}
+
+// Summarize the route's trades by leg, ship and good. r.mu must be held.
+func (r *route) Accounts() string {
+	legs := make(map[int]int)
+	ships := make(map[string]int)
+	goods := make(map[string]int)
+	var fuel, total int
+	for _, t := range r.Trades {
+		legs[t.Leg] += t.Value()
+		ships[t.Ship] += t.Value()
+		total += t.Value()
+		if t.Good == "FUEL" {
+			fuel += t.Total
+			continue
+		}
+		goods[t.Good] += t.Value()
+	}
+
+	first := r.Trades[0].Time
+	hours := time.Now().Sub(first).Hours()
+	res := []string{fmt.Sprintf("Accounts since %s:", first.Local().Format("2006/01/02 15:04"))}
+	i := func(format string, args ...interface{}) { res = append(res, fmt.Sprintf(format, args...)) }
+	if hours > 0 {
+		i("  Profit: %d (%.0f per hour), fuel spend: %d", total, float64(total)/hours, fuel)
+	} else {
+		i("  Profit: %d, fuel spend: %d", total, fuel)
+	}
+
+	i("  By leg:")
+	for l := range r.Destinations {
+		if v, ok := legs[l]; ok {
+			i("    %s -> %s: %d", r.Destinations[l], r.Destinations[(l+1)%len(r.Destinations)], v)
+		}
+	}
+	if v, ok := legs[offRoute]; ok {
+		i("    repositioning: %d", v)
+	}
+
+	i("  By ship:")
+	ids := []string{}
+	for s := range ships {
+		ids = append(ids, s)
+	}
+	sort.Strings(ids)
+	for _, s := range ids {
+		i("    %s: %d", shortShip(s), ships[s])
+	}
+
+	if len(goods) > 0 {
+		i("  By good (excluding fuel):")
+		names := []string{}
+		for g := range goods {
+			names = append(names, g)
+		}
+		sort.Strings(names)
+		for _, g := range names {
+			i("    %s: %d", g, goods[g])
+		}
+	}
+
+	return strings.Join(res, "\n")
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestAccounts(t *testing.T) {
+	r := newRoute("test")
+	r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+	r.AddStop("OE-B", simpleCargo("NONE"), tradeRules{})
+	s1 := &spacetraders.Ship{ID: "ship1"}
+	s2 := &spacetraders.Ship{ID: "ship2"}
+
+	r.Record(s1, 0, bsBuy, &spacetraders.Order{Good: "FUEL", Quantity: 5, PricePerUnit: 2, Total: 10})
+	r.Record(s1, 0, bsBuy, &spacetraders.Order{Good: "METALS", Quantity: 10, PricePerUnit: 5, Total: 50})
+	r.Record(s1, 0, bsSell, &spacetraders.Order{Good: "METALS", Quantity: 10, PricePerUnit: 8, Total: 80})
+	r.Record(s2, 1, bsBuy, &spacetraders.Order{Good: "FUEL", Quantity: 3, PricePerUnit: 2, Total: 6})
+	r.Record(s2, offRoute, bsBuy, &spacetraders.Order{Good: "FUEL", Quantity: 2, PricePerUnit: 2, Total: 4})
+
+	if r.Balance != 10 {
+		t.Errorf("want a balance of 10, got %d", r.Balance)
+	}
+	if !r.Trades[0].Buy || r.Trades[0].Value() != -10 || r.Trades[2].Buy || r.Trades[2].Value() != 80 {
+		t.Errorf("bad trades: %+v", r.Trades)
+	}
+
+	lines := strings.Split(r.Accounts(), "\n")
+	want := []string{
+		"  By leg:",
+		"    OE-A -> OE-B: 20",
+		"    OE-B -> OE-A: -6",
+		"    repositioning: -4",
+		"  By ship:",
+		"    ship1: 20",
+		"    ship2: -10",
+		"  By good (excluding fuel):",
+		"    METALS: 30",
+	}
+	if diff := cmp.Diff(want, lines[2:]); diff != "" {
+		t.Errorf("bad accounts: -want +got\n%s", diff)
+	}
+	if !strings.Contains(lines[1], "Profit: 10") || !strings.Contains(lines[1], "fuel spend: 20") {
+		t.Errorf("bad totals: %q", lines[1])
+	}
+
+	// Only the latest trades are kept, but the balance includes all of them
+	for i := 0; i < maxTrades; i++ {
+		r.Record(s1, 0, bsSell, &spacetraders.Order{Good: "METALS", Quantity: 1, PricePerUnit: 1, Total: 1})
+	}
+	if len(r.Trades) != maxTrades {
+		t.Errorf("want %d trades kept, got %d", maxTrades, len(r.Trades))
+	}
+	if r.Trades[0].Good != "METALS" || r.Trades[0].Total != 1 {
+		t.Errorf("oldest trades weren't dropped: %+v", r.Trades[0])
+	}
+	if r.Balance != 10+maxTrades {
+		t.Errorf("want a balance of %d, got %d", 10+maxTrades, r.Balance)
+	}
+}
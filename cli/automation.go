@@ -6,8 +6,11 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/zigdon/spacetraders"
+	"github.com/zigdon/spacetraders/tasks"
 )
 
 func init() {
@@ -17,8 +20,14 @@ func init() {
 			Name:    "CreateTradeRoute",
 			Usage:   "CreateTradeRoute <name> <location, cargo>...",
 			Help: "Create a new trade route. Ships on the route will go to each " +
-				"location in order, sell all their cargo, buy fuel for the next hop, " +
-				"and fill the remaining space with the specified cargo.",
+				"location in order, sell their cargo, buy fuel for the next hop, " +
+				"and fill the remaining space with the specified cargo. Cargo can be " +
+				"a single good, or GOOD[:PERCENT],GOOD[:PERCENT].../SELL,SELL... to buy " +
+				"several goods in order, each using a percent of the remaining space " +
+				"(or all of it), and only sell the listed goods, keeping the rest for " +
+				"a later stop. NONE means nothing to buy or sell. The route is checked " +
+				"against known markets, and won't be created if it can't work " +
+				"unless -force is given.",
 			Do:      doCreateTradeRoute,
 			MinArgs: 3,
 			MaxArgs: -1,
@@ -39,8 +48,19 @@ func init() {
 			Name:       "AddShipToRoute",
 			Usage:      "AddShipToRoute <route name> <ship id>",
 			Validators: []string{"", "ship"},
-			Help:       "Add a new ship to an existing trade route.",
-			Do:         doAddShipToRoute,
+			Help: "Add a new ship to an existing trade route. The route is checked to " +
+				"make sure the ship can fly and trade on it, use -force to add it anyway.",
+			Do:      doAddShipToRoute,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+		{
+			Section:    "Automation",
+			Name:       "RetryShipOnRoute",
+			Usage:      "RetryShipOnRoute <route name> <ship id>",
+			Validators: []string{"", "ship"},
+			Help:       "Clear the errors and quarantine of a ship on a route, so it is retried right away.",
+			Do:         doRetryShipOnRoute,
 			MinArgs:    2,
 			MaxArgs:    2,
 		},
@@ -53,16 +73,75 @@ func init() {
 	if err := RegisterPersistence("automation", save, load); err != nil {
 		log.Fatalf("Can't register load/save for automation: %v", err)
 	}
+
+	if err := tasks.RegisterKind("route", routeShipTask); err != nil {
+		log.Fatalf("Can't register route tasks: %v", err)
+	}
 }
 
 type route struct {
 	Name         string
 	Ships        map[string]int
+	States       map[string]*shipState
 	Destinations []string
-	Cargos       []string
+	Cargos       []stopCargo
+	Rules        []tradeRules
 	AutoFuel     bool
 	Balance      int
+	Budget       int
+	Paused       bool
 	LogEntries   []string
+	Trades       []trade
+
+	// Ships are processed in parallel, mu protects the route's state, and
+	// busy tracks which ships are being processed.
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// How many consecutive errors a ship can have before it's quarantined, and how
+// long to wait before retrying after an error.
+const (
+	maxShipErrors = 5
+	shipRetryBase = 20 * time.Second
+	shipRetryMax  = 30 * time.Minute
+)
+
+// How long to wait before checking again on a ship that should have arrived,
+// but is still in flight.
+const arrivalRecheck = 10 * time.Second
+
+// Tracks the health of a ship on a route
+type shipState struct {
+	Errors      int
+	LastError   string
+	RetryAt     time.Time
+	Quarantined bool
+	ArrivesAt   time.Time
+}
+
+func (st *shipState) String() string {
+	switch {
+	case st.Quarantined:
+		return fmt.Sprintf("QUARANTINED after %d errors: %s", st.Errors, st.LastError)
+	case st.Errors > 0 && st.RetryAt.After(time.Now()):
+		return fmt.Sprintf("%d errors, retrying in %s: %s",
+			st.Errors, st.RetryAt.Sub(time.Now()).Truncate(time.Second), st.LastError)
+	case st.Errors > 0:
+		return fmt.Sprintf("%d errors: %s", st.Errors, st.LastError)
+	}
+	return "ok"
+}
+
+// The route's fields, without the locking MarshalJSON
+type plainRoute route
+
+// Encode the route while holding its lock, as ships on it are processed in the
+// background.
+func (r *route) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal((*plainRoute)(r))
 }
 
 type saveData struct {
@@ -73,6 +152,8 @@ func save() string {
 	out := strings.Builder{}
 	enc := json.NewEncoder(&out)
 
+	routesMu.RLock()
+	defer routesMu.RUnlock()
 	mySave := saveData{Routes: routes}
 
 	if err := enc.Encode(mySave); err != nil {
@@ -90,63 +171,155 @@ func load(data string) error {
 	if err := dec.Decode(saved); err != nil {
 		return fmt.Errorf("error decoding json into %#v: %v\n%s", saved, err, data)
 	}
+	for _, r := range saved.Routes {
+		if r.States == nil {
+			r.States = make(map[string]*shipState)
+		}
+		// Routes saved before rules were added
+		for len(r.Rules) < len(r.Destinations) {
+			r.Rules = append(r.Rules, tradeRules{})
+		}
+	}
+	routesMu.Lock()
 	routes = saved.Routes
+	routesMu.Unlock()
 
 	return nil
 }
 
-var routes = make(map[string]*route)
+var (
+	// Protects the map of routes. Each route is protected by its own mu.
+	routesMu sync.RWMutex
+	routes   = make(map[string]*route)
+)
 
-// Take a list of [location, good], create a trade route
-func doCreateTradeRoute(c *spacetraders.Client, args []string) error {
-	name := args[0]
-	if r, ok := routes[strings.ToLower(name)]; ok {
-		return fmt.Errorf("a route already exists named %q: %s", name, r.Short())
+// All the routes, sorted by name
+func allRoutes() []*route {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	var res []*route
+	for _, r := range routes {
+		res = append(res, r)
 	}
+	sort.Slice(res, func(i, j int) bool { return strings.ToLower(res[i].Name) < strings.ToLower(res[j].Name) })
+	return res
+}
+
+// The names of all the routes, sorted
+func routeNames() []string {
+	var res []string
+	for _, r := range allRoutes() {
+		res = append(res, r.Name)
+	}
+	return res
+}
+
+// Add a new route, unless its name is taken
+func addRoute(r *route) error {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if old, ok := routes[strings.ToLower(r.Name)]; ok {
+		return fmt.Errorf("a route already exists named %q: %s", r.Name, old.Short())
+	}
+	routes[strings.ToLower(r.Name)] = r
+	return nil
+}
+
+// Remove a route by name
+func deleteRoute(name string) {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	delete(routes, strings.ToLower(name))
+}
+
+// Give a route a new name, unless it's taken
+func renameRoute(r *route, name string) error {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if old, ok := routes[strings.ToLower(name)]; ok {
+		return fmt.Errorf("a route already exists named %q: %s", name, old.Short())
+	}
+	delete(routes, strings.ToLower(r.Name))
+	r.mu.Lock()
+	r.Name = name
+	r.mu.Unlock()
+	routes[strings.ToLower(name)] = r
+	return nil
+}
+
+func newRoute(name string) *route {
 	log.Printf("Creating route %q", name)
-	r := &route{
+	return &route{
 		Name:         name,
 		AutoFuel:     true,
 		Destinations: []string{},
-		Cargos:       []string{},
+		Cargos:       []stopCargo{},
+		Rules:        []tradeRules{},
 		Ships:        make(map[string]int),
+		States:       make(map[string]*shipState),
 		LogEntries:   []string{},
 	}
+}
+
+// Add a new stop at the end of the route
+func (r *route) AddStop(loc string, cargo stopCargo, rules tradeRules) {
+	r.Destinations = append(r.Destinations, loc)
+	r.Cargos = append(r.Cargos, cargo)
+	r.Rules = append(r.Rules, rules)
+}
+
+// Find a route by name
+func getRoute(name string) (*route, error) {
+	routesMu.RLock()
+	r, ok := routes[strings.ToLower(name)]
+	routesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("can't find route %q. Available routes: %s", name, strings.Join(routeNames(), ", "))
+	}
+	return r, nil
+}
+
+// Take a list of [location, good], create a trade route
+func doCreateTradeRoute(c *spacetraders.Client, args []string) error {
+	name := args[0]
+	if err := checkNewRouteName(name); err != nil {
+		return err
+	}
+	r := newRoute(name)
 	pairs := args[1:]
 
 	for len(pairs) > 0 {
 		if len(pairs) < 2 {
 			return fmt.Errorf("Arguments must match: <name> <location, cargo>...; Got %q", args)
 		}
-		if err := validate(c, pairs[:2], []string{"location", "cargo"}); err != nil {
+		loc, cargo, err := parseStop(c, pairs[0], pairs[1])
+		if err != nil {
 			return fmt.Errorf("invalid pair %v for route: %v", pairs[:2], err)
 		}
-		r.Destinations = append(r.Destinations, pairs[0])
-		r.Cargos = append(r.Cargos, pairs[1])
+		r.AddStop(loc, cargo, tradeRules{})
 		pairs = pairs[2:]
 	}
+	if err := reportRoute(c, r, nil); err != nil {
+		return err
+	}
 
-	routes[strings.ToLower(name)] = r
+	if err := addRoute(r); err != nil {
+		return err
+	}
 	Out("Created route:\n%s", r.String())
 
 	return nil
 }
 
 func doShowTradeRoute(c *spacetraders.Client, args []string) error {
-	var rs []string
-	for _, r := range routes {
-		rs = append(rs, r.Name)
-	}
-	sort.Strings(rs)
-
 	if len(args) == 0 {
-		Out("Known routes: %s", strings.Join(rs, ", "))
+		Out("Known routes: %s", strings.Join(routeNames(), ", "))
 		return nil
 	}
 
-	r, ok := routes[strings.ToLower(args[0])]
-	if !ok {
-		return fmt.Errorf("Can't find route %q. Known routes: %s", args[0], strings.Join(rs, ", "))
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
 	}
 
 	Out(r.String())
@@ -154,15 +327,9 @@ func doShowTradeRoute(c *spacetraders.Client, args []string) error {
 }
 
 func doAddShipToRoute(c *spacetraders.Client, args []string) error {
-	name := args[0]
-	r, ok := routes[strings.ToLower(name)]
-	if !ok {
-		rs := []string{}
-		for _, r := range routes {
-			rs = append(rs, r.Name)
-		}
-		sort.Strings(rs)
-		return fmt.Errorf("can't find route %q. Available routes: %s", name, strings.Join(rs, ", "))
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
 	}
 
 	ship, err := getShip(c, args[1])
@@ -170,12 +337,22 @@ func doAddShipToRoute(c *spacetraders.Client, args []string) error {
 		return fmt.Errorf("can't find ship %q: %v", args[1], err)
 	}
 
-	if _, ok := r.Ships[ship.ID]; ok {
+	return addShipToRoute(c, r, ship)
+}
+
+// Add a ship to a route, and send it to the first stop if needed
+func addShipToRoute(c *spacetraders.Client, r *route, ship *spacetraders.Ship) error {
+	if r.hasShip(ship.ID) {
 		return fmt.Errorf("ship %s is already on route %s.", ship.ShortID, r.Name)
 	}
+	if err := reportRoute(c, r, ship); err != nil {
+		return err
+	}
 
 	r.Log("%s: Adding ship %q to route", ship.ShortID, ship.ID)
-	r.Ships[ship.ID] = 0
+	if err := r.AddShip(ship.ID); err != nil {
+		return err
+	}
 	if ship.LocationName != r.Destinations[0] {
 		fp, err := c.CreateFlight(ship.ID, r.Destinations[0])
 		if err != nil {
@@ -187,11 +364,48 @@ func doAddShipToRoute(c *spacetraders.Client, args []string) error {
 	return nil
 }
 
+func doRetryShipOnRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	ship, err := getShip(c, args[1])
+	if err != nil {
+		return fmt.Errorf("can't find ship %q: %v", args[1], err)
+	}
+	r.mu.Lock()
+	if _, ok := r.Ships[ship.ID]; !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("ship %s isn't on route %s.", ship.ShortID, r.Name)
+	}
+	r.States[ship.ID] = &shipState{}
+	r.mu.Unlock()
+	r.Log("%s: Cleared errors, retrying", ship.ShortID)
+
+	return nil
+}
+
+// Process all the routes. Errors in one route don't stop the others.
 func ProcessRoutes(c *spacetraders.Client) error {
-	for k, r := range routes {
-		if err := r.HandlePending(c); err != nil {
-			return fmt.Errorf("error handling route %s: %v", k, err)
-		}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []string
+	for _, r := range allRoutes() {
+		wg.Add(1)
+		go func(r *route) {
+			defer wg.Done()
+			if err := r.HandlePending(c); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("error handling route %s: %v", r.Name, err))
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
 	}
 
 	return nil
@@ -200,6 +414,8 @@ func ProcessRoutes(c *spacetraders.Client) error {
 func (r *route) Log(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	ui.Msg("%s: %s", r.Name, msg)
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.LogEntries = append(r.LogEntries, msg)
 	if len(r.LogEntries) > 10 {
 		purge := len(r.LogEntries) - 10
@@ -208,16 +424,32 @@ func (r *route) Log(format string, args ...interface{}) {
 }
 
 func (r *route) String() string {
-	res := []string{fmt.Sprintf("Route %q  Profit: %d", r.Name, r.Balance), "Locations:"}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []string{fmt.Sprintf("Route %q  Profit: %d", r.Name, r.Balance)}
+	if r.Budget > 0 {
+		res[0] += fmt.Sprintf("  Budget: %d/hour", r.Budget)
+	}
+	if r.Paused {
+		res[0] += "  (paused)"
+	}
+	res = append(res, "Locations:")
 	for i := range r.Destinations {
-		res = append(res, fmt.Sprintf("  %s: %s", r.Destinations[i], r.Cargos[i]))
+		stop := fmt.Sprintf("  %d. %s: %s", i+1, r.Destinations[i], r.Cargos[i])
+		if rules := r.Rules[i].String(); rules != "" {
+			stop += fmt.Sprintf(" [%s]", rules)
+		}
+		res = append(res, stop)
 	}
 	if len(r.Ships) > 0 {
 		res = append(res, "Ships:")
 		for s, i := range r.Ships {
-			res = append(res, fmt.Sprintf("  %s: -> %s", s, r.Destinations[i]))
+			res = append(res, fmt.Sprintf("  %s: -> %s (%s)", shortShip(s), r.Destinations[i], r.state(s)))
 		}
 	}
+	if len(r.Trades) > 0 {
+		res = append(res, r.Accounts())
+	}
 	if len(r.LogEntries) > 0 {
 		res = append(res, "Recent log entries:")
 		for _, l := range r.LogEntries {
@@ -229,83 +461,337 @@ func (r *route) String() string {
 }
 
 func (r *route) Short() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return fmt.Sprintf("%s: %d ships, trading: %v, locations: %v, profit: %d", r.Name, len(r.Ships), r.Cargos, r.Destinations, r.Balance)
 }
 
 func (r *route) AddShip(ship string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if i, ok := r.Ships[ship]; ok {
 		return fmt.Errorf("%s is already using this route, heading to %s", ship, r.Destinations[i])
 	}
 	r.Ships[ship] = 0
+	r.States[ship] = &shipState{}
 
 	return nil
 }
 
+func (r *route) hasShip(ship string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.Ships[ship]
+	return ok
+}
+
+// The IDs of the ships on the route, sorted
+func (r *route) shipIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []string
+	for s := range r.Ships {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
 func (r *route) DelShip(ship string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if _, ok := r.Ships[ship]; !ok {
 		return fmt.Errorf("%s isn't using this route.", ship)
 	}
 	delete(r.Ships, ship)
+	delete(r.States, ship)
 
 	return nil
 }
 
-func (r *route) HandlePending(c *spacetraders.Client) error {
-	for s, i := range r.Ships {
-		ship, err := getShip(c, s)
-		if err != nil {
-			return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
-		}
+// Get the state for a ship, creating it if needed. r.mu must be held.
+func (r *route) state(ship string) *shipState {
+	st, ok := r.States[ship]
+	if !ok {
+		st = &shipState{}
+		r.States[ship] = st
+	}
+	return st
+}
 
-		// Ship is still in flight
-		if ship.FlightPlanID != "" {
-			continue
-		}
+// Record an error for a ship, and back off exponentially before retrying it,
+// or quarantine it if it keeps failing.
+func (r *route) shipFailed(ship string, err error) {
+	r.mu.Lock()
+	st := r.state(ship)
+	st.Errors++
+	st.LastError = err.Error()
+	errors := st.Errors
+	if errors >= maxShipErrors {
+		st.Quarantined = true
+		r.mu.Unlock()
+		r.Log("%s: Quarantined after %d errors: %v", shortShip(ship), errors, err)
+		return
+	}
+
+	delay := shipRetryBase << uint(errors-1)
+	if delay > shipRetryMax {
+		delay = shipRetryMax
+	}
+	retry := time.Now().Add(delay)
+	st.RetryAt = retry
+	r.mu.Unlock()
+	r.Log("%s: Error #%d, retrying in %s: %v", shortShip(ship), errors, delay, err)
+	r.wakeAt(ship, fmt.Sprintf("retry%d", errors), retry)
+}
 
-		var expectedLocation string
-		expectedLocation = r.Destinations[i]
-		if ship.LocationName != expectedLocation {
-			return fmt.Errorf("%s isn't at the expected location for %s[%d]: at %q rather than %q",
-				ship.ShortID, r.Name, i, ship.LocationName, expectedLocation)
-		}
+// Clear a ship's errors once it's handled successfully
+func (r *route) shipSucceeded(ship string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.state(ship)
+	st.Errors = 0
+	st.LastError = ""
+}
 
-		// Sell cargo
-		if err := r.SellAll(c, ship); err != nil {
-			return fmt.Errorf("can't sell cargo from %q at %q: %v", ship.ShortID, ship.LocationName, err)
+// Schedule a ship to be processed at a time, rather than waiting for the next
+// poll of all the routes. These are saved with the other tasks, so arrivals
+// and retries aren't lost on restart.
+func (r *route) wakeAt(ship, reason string, when time.Time) {
+	key := fmt.Sprintf("route:%s:%s", ship, reason)
+	if err := tasks.GetTaskQueue().AddKind(key, "", when, tasks.Schedule{}, "route", ship); err != nil {
+		log.Printf("Can't schedule %s: %v", key, err)
+	}
+}
+
+func routeShipTask(args []string) (func(*spacetraders.Client) error, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("need a ship, got %q", args)
+	}
+	ship := args[0]
+	return func(c *spacetraders.Client) error {
+		return processRouteShip(c, ship)
+	}, nil
+}
+
+// Process a ship when its flight arrives
+func (r *route) wakeOnArrival(ship string, fp *spacetraders.FlightPlan) {
+	when := fp.ArrivesAt
+	if !when.After(time.Now()) {
+		when = time.Now().Add(arrivalRecheck)
+	}
+	r.mu.Lock()
+	r.state(ship).ArrivesAt = when
+	r.mu.Unlock()
+	r.wakeAt(ship, fmt.Sprintf("%s:%d", fp.ID, when.Unix()), when)
+}
+
+// Process a single ship on whatever route it's on
+func processRouteShip(c *spacetraders.Client, ship string) error {
+	for _, r := range allRoutes() {
+		r.mu.Lock()
+		_, ok := r.Ships[ship]
+		r.mu.Unlock()
+		if ok {
+			return r.processShip(c, ship)
 		}
+	}
+	log.Printf("%s is no longer on a route, not processing", ship)
+	return nil
+}
 
-		// Buy fuel for the next hop
-		ship, err = getShip(c, s)
-		if err != nil {
-			return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
+// Move along all the ships on the route that are ready. Each ship is handled
+// in its own goroutine, so one ship failing or being slow doesn't hold up the
+// others.
+func (r *route) HandlePending(c *spacetraders.Client) error {
+	r.mu.Lock()
+	if r.Paused {
+		r.mu.Unlock()
+		return nil
+	}
+	var ready []string
+	for s := range r.Ships {
+		// Ships with a flight in progress will be processed when they arrive
+		if !r.state(s).ArrivesAt.After(time.Now()) {
+			ready = append(ready, s)
 		}
-		if err := r.BuyFuel(c, ship, r.Destinations[i]); err != nil {
-			return fmt.Errorf("can't buy fuel for %q: %v", ship.ShortID, err)
+	}
+	r.mu.Unlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failed []string
+	for _, s := range ready {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			if err := r.processShip(c, s); err != nil {
+				mu.Lock()
+				failed = append(failed, shortShip(s))
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d ships failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Move a ship along the route if it's ready, and keep track of its errors
+func (r *route) processShip(c *spacetraders.Client, s string) error {
+	r.mu.Lock()
+	st := r.state(s)
+	i, ok := r.Ships[s]
+	if !ok || r.Paused || r.busy[s] || st.Quarantined || st.RetryAt.After(time.Now()) {
+		r.mu.Unlock()
+		return nil
+	}
+	if r.busy == nil {
+		r.busy = make(map[string]bool)
+	}
+	r.busy[s] = true
+	stop := r.snapshot(i)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.busy, s)
+		r.mu.Unlock()
+	}()
+
+	if err := r.handleShip(c, s, stop); err != nil {
+		r.shipFailed(s, err)
+		return err
+	}
+	r.shipSucceeded(s)
+
+	return nil
+}
+
+// A copy of the stop a ship is heading to, taken under r.mu, so editing the
+// route while the ship is handled doesn't change the stop under it
+type stopSnapshot struct {
+	index    int
+	stops    int
+	location string
+	next     string
+	cargo    stopCargo
+	rules    tradeRules
+}
+
+// Take a snapshot of a stop. r.mu must be held.
+func (r *route) snapshot(i int) stopSnapshot {
+	n := len(r.Destinations)
+	return stopSnapshot{
+		index:    i,
+		stops:    n,
+		location: r.Destinations[i],
+		next:     r.Destinations[(i+1)%n],
+		cargo:    r.Cargos[i],
+		rules:    r.Rules[i],
+	}
+}
+
+// Point a ship at the stop after the one it was handled at. If the route was
+// edited in the meantime, find where it's flying to again. A ship flying to a
+// stop that was removed keeps the stop the edit gave it, and is sent there
+// once it arrives.
+func (r *route) advance(ship string, stop stopSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.Ships[ship]
+	if !ok {
+		return
+	}
+	if i == stop.index && len(r.Destinations) == stop.stops && r.Destinations[i] == stop.location {
+		r.Ships[ship] = (i + 1) % stop.stops
+		return
+	}
+	for j, d := range r.Destinations {
+		if d == stop.next {
+			r.Ships[ship] = j
+			return
 		}
+	}
+}
+
+func (r *route) handleShip(c *spacetraders.Client, s string, stop stopSnapshot) error {
+	ship, err := cachedShip(c, s)
+	if err != nil {
+		return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
+	}
 
-		// Buy cargo
-		if r.Cargos[i] == "NONE" {
-			r.Log("%s: Not buying cargo at %s", ship.ShortID, ship.LocationName)
-		} else {
-			ship, err = getShip(c, s)
+	// Ship is still in flight, check back when it arrives
+	if ship.FlightPlanID != "" {
+		fp, ok := spacetraders.GetArrivalWatcher().Flight(ship.FlightPlanID)
+		if !ok {
+			f, err := c.ShowFlight(ship.FlightPlanID)
 			if err != nil {
-				return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
-			}
-			if err := r.BuyCargo(c, ship, r.Cargos[i]); err != nil {
-				return fmt.Errorf("can't buy cargo %s for %q: %v", r.Cargos[i], ship.ShortID, err)
+				return fmt.Errorf("can't check flight %s of %s: %v", ship.ShortFlightPlanID, ship.ShortID, err)
 			}
+			fp = *f
 		}
+		r.wakeOnArrival(s, &fp)
+		return nil
+	}
+
+	// Send a lost ship back to where it should be
+	expectedLocation := stop.location
+	if ship.LocationName != expectedLocation {
+		r.Log("%s: At %s rather than %s, rerouting", ship.ShortID, ship.LocationName, expectedLocation)
+		if err := r.BuyFuel(c, ship, offRoute, expectedLocation); err != nil {
+			return fmt.Errorf("can't buy fuel for %q: %v", ship.ShortID, err)
+		}
+		fp, err := c.CreateFlight(ship.ID, expectedLocation)
+		if err != nil {
+			return fmt.Errorf("%s isn't at the expected location for %s[%d], and can't be sent back from %q to %q: %v",
+				ship.ShortID, r.Name, stop.index, ship.LocationName, expectedLocation, err)
+		}
+		r.Log("%s: Created flight plan %s back to %s", ship.ShortID, fp.ShortID, expectedLocation)
+		r.wakeOnArrival(s, fp)
+		return nil
+	}
+
+	// Sell cargo
+	if err := r.SellAll(c, ship, stop); err != nil {
+		return fmt.Errorf("can't sell cargo from %q at %q: %v", ship.ShortID, ship.LocationName, err)
+	}
+
+	// Buy fuel for the next hop
+	nextDest := stop.next
+	ship, err = cachedShip(c, s)
+	if err != nil {
+		return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
+	}
+	if err := r.BuyFuel(c, ship, stop.index, nextDest); err != nil {
+		return fmt.Errorf("can't buy fuel for %q: %v", ship.ShortID, err)
+	}
 
-		// Fly on
-		var nextDest string
-		nextDest = r.Destinations[(i+1)%len(r.Destinations)]
-		fp, err := c.CreateFlight(ship.ID, nextDest)
+	// Buy cargo
+	if len(stop.cargo.Buy) == 0 {
+		r.Log("%s: Not buying cargo at %s", ship.ShortID, ship.LocationName)
+	} else {
+		ship, err = cachedShip(c, s)
 		if err != nil {
-			return fmt.Errorf("can't send %s to %s: %v", ship.ShortID, nextDest, err)
+			return fmt.Errorf("can't find ship %q for route %q: %v", s, r.Name, err)
+		}
+		if err := r.BuyCargo(c, ship, stop); err != nil {
+			return fmt.Errorf("can't buy cargo %s for %q: %v", stop.cargo, ship.ShortID, err)
 		}
-		r.Ships[s] = (i + 1) % len(r.Destinations)
-		r.Log("%s: Created flight plan %s to %s", ship.ShortID, fp.ShortID, nextDest)
 	}
+
+	// Fly on
+	fp, err := c.CreateFlight(ship.ID, nextDest)
+	if err != nil {
+		return fmt.Errorf("can't send %s to %s: %v", ship.ShortID, nextDest, err)
+	}
+	r.advance(s, stop)
+	r.Log("%s: Created flight plan %s to %s", ship.ShortID, fp.ShortID, nextDest)
+	r.wakeOnArrival(s, fp)
+
 	return nil
 }
 
@@ -320,7 +806,9 @@ var (
 	bsSell bsType = false
 )
 
-func (r *route) BuySell(c *spacetraders.Client, ship *spacetraders.Ship, bs bsType, good string, qty int) error {
+// Buy or sell goods in batches the ship can load, and record each order
+// against the given leg of the route.
+func (r *route) BuySell(c *spacetraders.Client, ship *spacetraders.Ship, leg int, bs bsType, good string, qty int) error {
 	var f func(string, string, int) (*spacetraders.Order, error)
 	var verb string
 	if bs == bsSell {
@@ -340,34 +828,58 @@ func (r *route) BuySell(c *spacetraders.Client, ship *spacetraders.Ship, bs bsTy
 			return fmt.Errorf("%s: error %s %d %q: %v", ship.ShortID, verb, sell, good, err)
 		}
 		qty -= sell
-		r.Balance += o.Total
+		r.Record(ship, leg, bs, o)
 	}
 	return nil
 }
 
-func (r *route) SellAll(c *spacetraders.Client, ship *spacetraders.Ship) error {
+// Sell all the cargo the stop's rules allow. The cargo was carried on the
+// previous leg.
+func (r *route) SellAll(c *spacetraders.Client, ship *spacetraders.Ship, stop stopSnapshot) error {
+	leg := (stop.index + stop.stops - 1) % stop.stops
+	var market []spacetraders.Offer
 	for _, g := range ship.Cargo {
 		if g.Good == "FUEL" {
 			continue
 		}
+		if !stop.cargo.Sells(g.Good) {
+			r.Log("%s: Keeping %d %s for a later stop", ship.ShortID, g.Quantity, g.Good)
+			continue
+		}
+		if market == nil {
+			var err error
+			market, err = c.Marketplace(ship.LocationName)
+			if err != nil {
+				return fmt.Errorf("can't check market at %q: %v", ship.LocationName, err)
+			}
+		}
+		var offer *spacetraders.Offer
+		for i, o := range market {
+			if o.Symbol == g.Good {
+				offer = &market[i]
+			}
+		}
+		if offer == nil {
+			r.Log("%s: Holding %d %s, not traded at %s", ship.ShortID, g.Quantity, g.Good, ship.LocationName)
+			continue
+		}
+		paid, known := r.lastPurchase(ship.ID, g.Good)
+		if ok, why := stop.rules.CanSell(offer.SellPricePerUnit, paid, known); !ok {
+			r.Log("%s: Holding %d %s at %s: %s", ship.ShortID, g.Quantity, g.Good, ship.LocationName, why)
+			continue
+		}
+
 		qty := g.Quantity
 		r.Log("%s: selling %d %s at %s", ship.ShortID, qty, g.Good, ship.LocationName)
-		if err := r.BuySell(c, ship, bsSell, g.Good, qty); err != nil {
+		if err := r.BuySell(c, ship, leg, bsSell, g.Good, qty); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *route) BuyFuel(c *spacetraders.Client, ship *spacetraders.Ship, dest string) error {
-	curFuel := 0
-	if len(ship.Cargo) > 0 {
-		good := ship.Cargo[0]
-		if good.Good != "FUEL" {
-			return fmt.Errorf("%q had some cargo that isn't fuel (%q)", ship.ShortID, good.Good)
-		}
-		curFuel = good.Quantity
-	}
+func (r *route) BuyFuel(c *spacetraders.Client, ship *spacetraders.Ship, leg int, dest string) error {
+	curFuel := fuelOnBoard(ship)
 
 	curLoc, err := getLocation(c, ship.LocationName)
 	if err != nil {
@@ -384,41 +896,46 @@ func (r *route) BuyFuel(c *spacetraders.Client, ship *spacetraders.Ship, dest st
 		return nil
 	}
 
+	// Use the last price we've seen, the market isn't checked before buying fuel
+	cost := 0
+	if m := spacetraders.GetMarketHistory().Latest(ship.LocationName); m != nil {
+		if o := m.Offer("FUEL"); o != nil {
+			cost = fuelNeeded * o.PurchasePricePerUnit
+		}
+	}
+	if err := credits.Claim(c, r, cost, true); err != nil {
+		return fmt.Errorf("can't buy %d fuel: %v", fuelNeeded, err)
+	}
+	defer credits.Release(r, cost)
+
 	r.Log("%s: Buying %d fuel for trip to %s", ship.ShortID, fuelNeeded, dest)
-	return r.BuySell(c, ship, bsBuy, "FUEL", fuelNeeded)
+	return r.BuySell(c, ship, leg, bsBuy, "FUEL", fuelNeeded)
 }
 
-func (r *route) BuyCargo(c *spacetraders.Client, ship *spacetraders.Ship, cargo string) error {
+// Buy the stop's cargo, in order of priority
+func (r *route) BuyCargo(c *spacetraders.Client, ship *spacetraders.Ship, stop stopSnapshot) error {
 	market, err := c.Marketplace(ship.LocationName)
 	if err != nil {
 		return fmt.Errorf("can't check market at %q: %v", ship.LocationName, err)
 	}
-	var offer *spacetraders.Offer
-	for _, o := range market {
-		if o.Symbol != cargo {
+
+	for _, p := range stop.cargo.Purchases(ship.SpaceAvailable, market, stop.rules) {
+		if p.Skip != "" {
+			r.Log("%s: Not buying %s at %s: %s", ship.ShortID, p.Good, ship.LocationName, p.Skip)
 			continue
 		}
-		offer = &o
-		break
-	}
-	if offer == nil {
-		return fmt.Errorf("can't find %q on offer at %q", cargo, ship.LocationName)
-	}
 
-	buy := ship.SpaceAvailable / offer.VolumePerUnit
-	if buy > offer.QuantityAvailable {
-		buy = offer.QuantityAvailable
-		r.Log("%s: Only %d of %s available at %s", ship.ShortID, buy, cargo, ship.LocationName)
+		cost := p.Qty * p.Price
+		if err := credits.Claim(c, r, cost, false); err != nil {
+			return fmt.Errorf("can't buy %d of %s at %s: %v", p.Qty, p.Good, ship.LocationName, err)
+		}
+		r.Log("%s: Buying %d of %s at %s", ship.ShortID, p.Qty, p.Good, ship.LocationName)
+		err := r.BuySell(c, ship, stop.index, bsBuy, p.Good, p.Qty)
+		credits.Release(r, cost)
+		if err != nil {
+			return err
+		}
 	}
 
-	acc, err := c.Account()
-	if err != nil {
-		return fmt.Errorf("can't get account info: %v", err)
-	}
-	if buy*offer.PricePerUnit > acc.Credits {
-		return fmt.Errorf("can't afford to buy %d of %s at %s, only %d credits available.",
-			buy, cargo, ship.LocationName, acc.Credits)
-	}
-	r.Log("%s: Buying %d of %s at %s", ship.ShortID, buy, cargo, ship.LocationName)
-	return r.BuySell(c, ship, bsBuy, cargo, buy)
+	return nil
 }
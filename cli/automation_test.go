@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+	"github.com/zigdon/spacetraders/tasks"
+)
+
+// Tasks for a ship scheduled by wakeAt, by key
+func routeTasks(t *testing.T, ship string) map[string]time.Time {
+	t.Helper()
+	res := make(map[string]time.Time)
+	for _, ti := range tasks.GetTaskQueue().List() {
+		if strings.HasPrefix(ti.Key, "route:"+ship+":") {
+			res[ti.Key] = ti.When
+		}
+	}
+	return res
+}
+
+func cancelRouteTasks(t *testing.T, ship string) {
+	t.Helper()
+	for k := range routeTasks(t, ship) {
+		if err := tasks.Cancel(k); err != nil {
+			t.Errorf("can't cancel %s: %v", k, err)
+		}
+	}
+}
+
+func TestShipFailed(t *testing.T) {
+	defer SetTUI(ui)
+	SetTUI(&recordUI{})
+	const ship = "failing-ship"
+	defer cancelRouteTasks(t, ship)
+
+	r := newRoute("test")
+	r.AddShip(ship)
+	for n := 1; n < maxShipErrors; n++ {
+		start := time.Now()
+		r.shipFailed(ship, fmt.Errorf("error %d", n))
+		st := r.States[ship]
+		if st.Errors != n || st.LastError != fmt.Sprintf("error %d", n) || st.Quarantined {
+			t.Fatalf("after %d errors: bad state %+v", n, st)
+		}
+		delay := shipRetryBase << uint(n-1)
+		if st.RetryAt.Before(start.Add(delay)) || st.RetryAt.After(time.Now().Add(delay)) {
+			t.Errorf("after %d errors: want a retry in %s, got %s", n, delay, st.RetryAt.Sub(start))
+		}
+		key := fmt.Sprintf("route:%s:retry%d", ship, n)
+		if when, ok := routeTasks(t, ship)[key]; !ok || !when.Equal(st.RetryAt) {
+			t.Errorf("after %d errors: want %s at %s, got %v", n, key, st.RetryAt, routeTasks(t, ship))
+		}
+	}
+
+	// Success resets the count
+	r.shipSucceeded(ship)
+	if st := r.States[ship]; st.Errors != 0 || st.LastError != "" || st.Quarantined {
+		t.Errorf("after success: bad state %+v", st)
+	}
+
+	for n := 1; n <= maxShipErrors; n++ {
+		r.shipFailed(ship, fmt.Errorf("error %d", n))
+	}
+	if st := r.States[ship]; !st.Quarantined || st.Errors != maxShipErrors {
+		t.Errorf("want quarantine after %d errors, got %+v", maxShipErrors, st)
+	}
+}
+
+func TestWakeOnArrival(t *testing.T) {
+	const ship = "flying-ship"
+	defer cancelRouteTasks(t, ship)
+
+	r := newRoute("test")
+	r.AddShip(ship)
+	arrives := time.Now().Add(time.Hour).Truncate(time.Second)
+	r.wakeOnArrival(ship, &spacetraders.FlightPlan{ID: "flight1", ArrivesAt: arrives})
+	if got := r.States[ship].ArrivesAt; !got.Equal(arrives) {
+		t.Errorf("want arrival at %s, got %s", arrives, got)
+	}
+
+	// A flight that's already due is checked again shortly
+	start := time.Now()
+	r.wakeOnArrival(ship, &spacetraders.FlightPlan{ID: "flight2", ArrivesAt: start.Add(-time.Minute)})
+	late := r.States[ship].ArrivesAt
+	if late.Before(start.Add(arrivalRecheck)) || late.After(time.Now().Add(arrivalRecheck)) {
+		t.Errorf("want a recheck in %s, got %s", arrivalRecheck, late.Sub(start))
+	}
+
+	r.wakeAt(ship, "retry1", arrives)
+
+	want := map[string]time.Time{
+		fmt.Sprintf("route:%s:flight1:%d", ship, arrives.Unix()): arrives,
+		fmt.Sprintf("route:%s:flight2:%d", ship, late.Unix()):    late,
+		fmt.Sprintf("route:%s:retry1", ship):                     arrives,
+	}
+	if diff := cmp.Diff(want, routeTasks(t, ship)); diff != "" {
+		t.Errorf("bad tasks: -want +got\n%s", diff)
+	}
+}
+
+func TestFreshShip(t *testing.T) {
+	defer cache.ClearObjs(spacetraders.SHIPOBJ)
+	now := time.Now()
+	cache.StoreObjs(spacetraders.SHIPOBJ, []interface{}{
+		&spacetraders.Ship{ID: "ship1", ShortID: "s-1", LocationName: "OE-PM"},
+		&spacetraders.Ship{ID: "ship2", ShortID: "s-2", FlightPlanID: "unwatched"},
+	})
+
+	tests := []struct {
+		desc string
+		id   string
+		now  time.Time
+		want string
+	}{
+		{desc: "by ID", id: "ship1", now: now, want: "OE-PM"},
+		{desc: "by short ID", id: "s-1", now: now, want: "OE-PM"},
+		{desc: "unknown", id: "s-3", now: now},
+		{desc: "stale", id: "s-1", now: now.Add(shipCacheAge + time.Second)},
+		{desc: "flight not known", id: "s-2", now: now},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := freshShip(tc.id, tc.now)
+			switch {
+			case tc.want == "" && s != nil:
+				t.Errorf("want nothing, got %+v", s)
+			case tc.want != "" && (s == nil || s.LocationName != tc.want):
+				t.Errorf("want a ship at %s, got %+v", tc.want, s)
+			}
+		})
+	}
+}
+
+func TestSnapshotAndAdvance(t *testing.T) {
+	tests := []struct {
+		desc string
+		edit func(r *route) error
+		want map[string]int
+	}{
+		{
+			desc: "no edits",
+			edit: func(r *route) error { return nil },
+			want: map[string]int{"s1": 2},
+		},
+		{
+			desc: "stop inserted before",
+			edit: func(r *route) error { return r.InsertStop(0, "OE-X", simpleCargo("FUEL")) },
+			want: map[string]int{"s1": 3},
+		},
+		{
+			desc: "stop replaced",
+			edit: func(r *route) error { return r.ReplaceStop(1, "OE-X", simpleCargo("FUEL")) },
+			want: map[string]int{"s1": 2},
+		},
+		{
+			desc: "next stop removed",
+			edit: func(r *route) error { return r.RemoveStop(2) },
+			want: map[string]int{"s1": 1},
+		},
+		{
+			desc: "ship removed",
+			edit: func(r *route) error { return r.DelShip("s1") },
+			want: map[string]int{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRoute("test")
+			r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+			r.AddStop("OE-B", simpleCargo("NONE"), tradeRules{MaxBuy: 10})
+			r.AddStop("OE-C", simpleCargo("DRONES"), tradeRules{})
+			r.Ships = map[string]int{"s1": 1}
+
+			r.mu.Lock()
+			stop := r.snapshot(1)
+			r.mu.Unlock()
+			if err := tc.edit(r); err != nil {
+				t.Fatalf("can't edit: %v", err)
+			}
+
+			// The snapshot isn't affected by the edit
+			if stop.location != "OE-B" || stop.next != "OE-C" || stop.stops != 3 ||
+				stop.cargo.String() != "NONE" || stop.rules.MaxBuy != 10 {
+				t.Errorf("snapshot changed: %+v", stop)
+			}
+			r.advance("s1", stop)
+			if diff := cmp.Diff(tc.want, r.Ships); diff != "" {
+				t.Errorf("bad ships: -want +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcessShipSkips(t *testing.T) {
+	tests := []struct {
+		desc  string
+		setup func(r *route)
+	}{
+		{desc: "paused", setup: func(r *route) { r.Paused = true }},
+		{desc: "busy", setup: func(r *route) { r.busy = map[string]bool{"s1": true} }},
+		{desc: "quarantined", setup: func(r *route) { r.States["s1"].Quarantined = true }},
+		{desc: "retrying later", setup: func(r *route) { r.States["s1"].RetryAt = time.Now().Add(time.Hour) }},
+		{desc: "not on the route", setup: func(r *route) { r.DelShip("s1") }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRoute("test")
+			r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+			r.AddShip("s1")
+			tc.setup(r)
+			// Handling the ship would need the API, which a nil client doesn't have
+			if err := r.processShip(nil, "s1"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Run with -race to check that routes can be edited while ships are handled
+func TestRoutesConcurrently(t *testing.T) {
+	defer SetTUI(ui)
+	SetTUI(&recordUI{})
+
+	r := newRoute("concurrent-main")
+	r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+	r.AddStop("OE-B", simpleCargo("NONE"), tradeRules{})
+	r.Paused = true
+	if err := addRoute(r); err != nil {
+		t.Fatalf("can't add route: %v", err)
+	}
+	defer deleteRoute(r.Name)
+
+	var wg sync.WaitGroup
+	run := func(f func(n int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				f(n)
+			}
+		}()
+	}
+	run(func(n int) {
+		name := fmt.Sprintf("concurrent-%d", n)
+		if err := addRoute(newRoute(name)); err != nil {
+			t.Errorf("can't add %s: %v", name, err)
+		}
+		deleteRoute(name)
+	})
+	run(func(int) {
+		if _, err := getRoute("concurrent-main"); err != nil {
+			t.Errorf("can't get route: %v", err)
+		}
+		allRoutes()
+	})
+	run(func(int) {
+		processRouteShip(nil, "not-a-ship")
+		r.HandlePending(nil)
+	})
+	run(func(n int) {
+		r.InsertStop(1, fmt.Sprintf("OE-%d", n), simpleCargo("FUEL"))
+		r.RemoveStop(1)
+	})
+	run(func(int) {
+		r.mu.Lock()
+		stop := r.snapshot(len(r.Destinations) - 1)
+		r.mu.Unlock()
+		r.advance("s1", stop)
+	})
+	run(func(n int) {
+		ship := fmt.Sprintf("concurrent-ship-%d", n)
+		r.AddShip(ship)
+		r.DelShip(ship)
+	})
+	run(func(int) {
+		r.SetRules(1, []string{"maxbuy=5"})
+	})
+	run(func(int) {
+		r.hasShip("s1")
+		r.shipIDs()
+		save()
+	})
+	wg.Wait()
+
+	if diff := cmp.Diff([]string{"OE-A", "OE-B"}, r.Destinations); diff != "" {
+		t.Errorf("bad stops: -want +got\n%s", diff)
+	}
+}
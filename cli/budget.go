@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Automation",
+			Name:    "Budget",
+			Usage:   "Budget",
+			Help: "Show the credit reserve, credits set aside for purchases in progress, " +
+				"and how much each route spent on cargo in the last hour.",
+			Do: doBudget,
+		},
+		{
+			Section: "Automation",
+			Name:    "SetReserve",
+			Usage:   "SetReserve <credits>",
+			Help: "Keep credits aside that automation won't spend on cargo, for " +
+				"example for loan repayments. Ships can still use them to buy fuel.",
+			Do:      doSetReserve,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Automation",
+			Name:    "SetRouteBudget",
+			Usage:   "SetRouteBudget <route name> <credits per hour|none>",
+			Help:    "Limit how much a route can spend on cargo in an hour. Fuel isn't limited.",
+			Do:      doSetRouteBudget,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+
+	if err := RegisterPersistence("budget", credits.Save, credits.Load); err != nil {
+		log.Fatalf("Can't register load/save for budget: %v", err)
+	}
+}
+
+// How far back a route's spending counts against its budget
+const budgetPeriod = time.Hour
+
+// Decides what automation can spend. Credits are set aside for each purchase
+// until it's done, so ships trading at the same time can't spend the same
+// credits twice.
+type budget struct {
+	mu       sync.Mutex
+	Reserve  int
+	reserved int
+	routes   map[*route]int
+}
+
+var credits = &budget{routes: make(map[*route]int)}
+
+// Set aside credits for a purchase by a route. Fuel can dip into the reserve
+// and isn't limited by the route's budget, so ships don't get stranded.
+// Credits come from the cached account, which the client keeps current as it
+// trades, so claims don't wait on the API.
+func (b *budget) Claim(c *spacetraders.Client, r *route, amount int, fuel bool) error {
+	if _, err := cachedCredits(); err != nil {
+		if _, err := c.Account(); err != nil {
+			return fmt.Errorf("can't get account info: %v", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	have, err := cachedCredits()
+	if err != nil {
+		return fmt.Errorf("can't get account info: %v", err)
+	}
+	avail := have - b.reserved
+	if !fuel {
+		avail -= b.Reserve
+	}
+	if amount > avail {
+		return fmt.Errorf("can't afford %d credits, only %d available", amount, avail)
+	}
+
+	if cap := r.budget(); !fuel && cap > 0 {
+		spent := r.spentSince(time.Now().Add(-budgetPeriod)) + b.routes[r]
+		if spent+amount > cap {
+			return fmt.Errorf("route budget of %d per hour exceeded, %d already spent", cap, spent)
+		}
+	}
+
+	b.reserved += amount
+	b.routes[r] += amount
+
+	return nil
+}
+
+// Return credits set aside by Claim, once the purchase is done
+func (b *budget) Release(r *route, amount int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved -= amount
+	b.routes[r] -= amount
+	if b.routes[r] == 0 {
+		delete(b.routes, r)
+	}
+}
+
+func (b *budget) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fmt.Sprintf("Reserve: %d, set aside for purchases in progress: %d", b.Reserve, b.reserved)
+}
+
+func (b *budget) Save() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.Marshal(b)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (b *budget) Load(data string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := json.Unmarshal([]byte(data), b); err != nil {
+		return fmt.Errorf("error decoding budget: %v", err)
+	}
+	return nil
+}
+
+func (r *route) budget() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Budget
+}
+
+// How much the route has spent on cargo other than fuel since a time
+func (r *route) spentSince(t time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spent := 0
+	for i := len(r.Trades) - 1; i >= 0 && r.Trades[i].Time.After(t); i-- {
+		if r.Trades[i].Buy && r.Trades[i].Good != "FUEL" {
+			spent += r.Trades[i].Total
+		}
+	}
+	return spent
+}
+
+func doBudget(c *spacetraders.Client, args []string) error {
+	Out(credits.String())
+
+	for _, r := range allRoutes() {
+		limit := "no limit"
+		if b := r.budget(); b > 0 {
+			limit = fmt.Sprintf("limit %d", b)
+		}
+		Out("  %s: spent %d in the last hour (%s)", r.Name, r.spentSince(time.Now().Add(-budgetPeriod)), limit)
+	}
+
+	return nil
+}
+
+func doSetReserve(c *spacetraders.Client, args []string) error {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid number of credits %q", args[0])
+	}
+
+	credits.mu.Lock()
+	credits.Reserve = n
+	credits.mu.Unlock()
+	Out(credits.String())
+
+	return nil
+}
+
+func doSetRouteBudget(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	if strings.ToLower(args[1]) != "none" {
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of credits %q", args[1])
+		}
+	}
+
+	r.mu.Lock()
+	r.Budget = n
+	r.mu.Unlock()
+	if n == 0 {
+		r.Log("Budget removed")
+	} else {
+		r.Log("Budget set to %d per hour", n)
+	}
+
+	return nil
+}
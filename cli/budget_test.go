@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func TestSpentSince(t *testing.T) {
+	now := time.Now()
+	r := newRoute("test")
+	r.Trades = []trade{
+		{Time: now.Add(-2 * time.Hour), Good: "METALS", Total: 100, Buy: true},
+		{Time: now.Add(-30 * time.Minute), Good: "METALS", Total: 10, Buy: true},
+		{Time: now.Add(-20 * time.Minute), Good: "FUEL", Total: 5, Buy: true},
+		{Time: now.Add(-10 * time.Minute), Good: "METALS", Total: 50, Buy: false},
+		{Time: now.Add(-5 * time.Minute), Good: "DRONES", Total: 20, Buy: true},
+	}
+
+	if got := r.spentSince(now.Add(-budgetPeriod)); got != 30 {
+		t.Errorf("want 30 spent in the last hour, got %d", got)
+	}
+	if got := r.spentSince(now.Add(-3 * time.Hour)); got != 130 {
+		t.Errorf("want 130 spent in the last 3 hours, got %d", got)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	defer cache.ClearObjs(spacetraders.USEROBJ)
+	// A nil client means claims must use the cached credits
+	cache.StoreObjs(spacetraders.USEROBJ, []interface{}{&spacetraders.User{Credits: 1000}})
+	b := &budget{Reserve: 200, routes: make(map[*route]int)}
+	r := newRoute("test")
+
+	if err := b.Claim(nil, r, 400, false); err != nil {
+		t.Fatalf("can't claim 400: %v", err)
+	}
+	// 400 are set aside, and 200 are in reserve
+	if err := b.Claim(nil, r, 500, false); err == nil {
+		t.Errorf("claimed more than is available")
+	}
+	// Fuel can use the reserve
+	if err := b.Claim(nil, r, 500, true); err != nil {
+		t.Errorf("can't claim fuel: %v", err)
+	}
+	b.Release(r, 500)
+
+	r.Budget = 500
+	if err := b.Claim(nil, r, 150, false); err == nil {
+		t.Errorf("route went over its budget")
+	}
+	b.Release(r, 400)
+	if err := b.Claim(nil, r, 150, false); err != nil {
+		t.Errorf("can't claim after release: %v", err)
+	}
+}
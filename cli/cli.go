@@ -9,6 +9,7 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/zigdon/spacetraders"
 )
@@ -27,8 +28,18 @@ type cmd struct {
 	MinArgs    int
 	MaxArgs    int
 	Aliases    []string
+	// Can't be run by At, Every or OnArrival
+	NoSchedule bool
 }
 
+// Set when the current command was given -force, to go ahead with routes that
+// can't work
+var forced bool
+
+// Commands share the output buffer and forced, so only one runs at a time.
+// Anything else writing to the output buffer must hold it too.
+var runMu sync.Mutex
+
 var (
 	commands    = map[string]*cmd{}
 	aliases     = map[string]string{}
@@ -117,6 +128,67 @@ func Register(c cmd) error {
 	return nil
 }
 
+// Run a line typed by the user, printing its output. Returns the command's
+// error, which has already been shown.
+func RunLine(c *spacetraders.Client, line string) error {
+	runMu.Lock()
+	defer runMu.Unlock()
+	cmd, args, err := ParseLine(c, line)
+	if err != nil {
+		ErrMsg(err.Error())
+		return nil
+	}
+
+	err = cmd.Do(c, args)
+	if err == ErrExit {
+		return err
+	}
+	if err != nil {
+		ErrMsg("Error: %v", err)
+	}
+	Out("")
+
+	return err
+}
+
+// Run a line in the background, returning its output rather than printing it.
+// Commands share the output buffer, so this holds runMu until the command is
+// done, and typed commands wait for it. Commands that keep going after they
+// return, like Wait, can't be scheduled.
+func runCaptured(c *spacetraders.Client, line string) (string, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	cmd, args, err := ParseLine(c, line)
+	if err != nil {
+		return "", err
+	}
+	if cmd.NoSchedule {
+		return "", fmt.Errorf("%s can't be scheduled", cmd.Name)
+	}
+
+	saved := outputBuffer
+	outputBuffer = []string{}
+	err = cmd.Do(c, args)
+	out := strings.Join(outputBuffer, "\n")
+	outputBuffer = saved
+
+	return out, err
+}
+
+// Show messages from background tasks. Waits for any running command to
+// finish, so they don't end up in its output.
+func Notify(msgs []string) {
+	if len(msgs) == 0 {
+		return
+	}
+	runMu.Lock()
+	defer runMu.Unlock()
+	for _, m := range msgs {
+		Out("%s", m)
+	}
+	Out("")
+}
+
 func ParseLine(c *spacetraders.Client, line string) (*cmd, []string, error) {
 	words := strings.Split(strings.TrimSpace(line), " ")
 	matches := filter(allCommands, words[0], filterPrefix)
@@ -136,10 +208,20 @@ func ParseLine(c *spacetraders.Client, line string) (*cmd, []string, error) {
 		log.Fatalf("Command %q not found!", words[0])
 	}
 
+	// -f skips validating the arguments, -force skips the checks on routes
 	var skipCache bool
+	forced = false
 	args := words[1:]
-	if len(args) > 0 && args[0] == "-f" {
-		skipCache = true
+flags:
+	for len(args) > 0 {
+		switch args[0] {
+		case "-f":
+			skipCache = true
+		case "-force":
+			forced = true
+		default:
+			break flags
+		}
 		args = args[1:]
 	}
 
@@ -239,7 +321,7 @@ func validate(c *spacetraders.Client, words []string, validators []string) error
 		var ft filterType = filterContains
 		validOpts := []string{}
 		if v == "window" {
-			validOpts = []string{"all", "msgs", "sidebar", "logs"}
+			validOpts = []string{"all", "msgs", "sidebar", "logs", "tasks"}
 			ft = filterPrefix
 		} else {
 		  var err error
@@ -279,11 +361,12 @@ func init() {
 			MaxArgs: 1,
 		},
 		{
-			Name:    "Quit",
-			Usage:   "Quit",
-			Help:    "Exit game",
-			Do:      doQuit,
-			Aliases: []string{"Exit"},
+			Name:       "Quit",
+			Usage:      "Quit",
+			Help:       "Exit game",
+			Do:         doQuit,
+			Aliases:    []string{"Exit"},
+			NoSchedule: true,
 		},
 		{
 			Name:    "Save",
@@ -293,17 +376,18 @@ func init() {
 			MaxArgs: 1,
 		},
 		{
-			Name:    "Load",
-			Usage:   "Load [filename]",
-			Help:    "Load client state from file. If not specified, load from spacetraders.save",
-			Do:      doLoad,
-			MaxArgs: 1,
+			Name:       "Load",
+			Usage:      "Load [filename]",
+			Help:       "Load client state from file. If not specified, load from spacetraders.save",
+			Do:         doLoad,
+			MaxArgs:    1,
+			NoSchedule: true,
 		},
 		{
 			Name:    "Toggle",
 			Usage:   "Toggle [window]",
 			Validators: []string{"window"},
-			Help:    "Open or close one of the UI's windows. Values are msgs/sidebar/logs/all",
+			Help:    "Open or close one of the UI's windows. Values are msgs/sidebar/logs/tasks/all",
 			Do:      doToggle,
 			MaxArgs: 1,
 		},
@@ -398,7 +482,7 @@ func doHelp(c *spacetraders.Client, args []string) error {
 		"<arguments> are required, [options] are optional.",
 		"",
 	}
-	for _, s := range []string{"", "Account", "Loans", "Ships", "Flight Plans", "Locations", "Goods and Cargo", "Automation"} {
+	for _, s := range []string{"", "Account", "Loans", "Ships", "Flight Plans", "Locations", "Goods and Cargo", "Automation", "Tasks"} {
 		if s != "" {
 			res = append(res, fmt.Sprintf("  %s:", s))
 		}
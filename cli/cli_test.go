@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestParseLineFlags(t *testing.T) {
+	defer func() { forced = false }()
+	cache.Store(spacetraders.SHIPS, []string{"parse-ship"}, nil)
+
+	tests := []struct {
+		line       string
+		wantArgs   []string
+		wantForced bool
+		wantErr    bool
+	}{
+		{line: "sell parse-ship METALS 10", wantArgs: []string{"parse-ship", "METALS", "10"}},
+		{line: "sell no-such-ship METALS 10", wantErr: true},
+		{line: "sell -f no-such-ship METALS 10", wantArgs: []string{"no-such-ship", "METALS", "10"}},
+		{line: "sell -force parse-ship METALS 10", wantArgs: []string{"parse-ship", "METALS", "10"}, wantForced: true},
+		// -force doesn't skip validating the arguments
+		{line: "sell -force no-such-ship METALS 10", wantErr: true},
+		{line: "sell -f -force no-such-ship METALS 10", wantArgs: []string{"no-such-ship", "METALS", "10"}, wantForced: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			_, args, err := ParseLine(nil, tc.line)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got %q", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("can't parse: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantArgs, args); diff != "" {
+				t.Errorf("bad args: -want +got\n%s", diff)
+			}
+			if forced != tc.wantForced {
+				t.Errorf("want forced %v, got %v", tc.wantForced, forced)
+			}
+		})
+	}
+}
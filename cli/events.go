@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Name:  "Events",
+			Usage: "Events [follow|stop] [type...]",
+			Help: "Show recent game events, optionally only of some types. follow " +
+				"shows new events in the messages window as they happen, until stop. " +
+				"Types are " + eventTypes() + ".",
+			Do:      doEvents,
+			MaxArgs: -1,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+// How many events to show at most
+const eventsShown = 20
+
+// Stops following events, if we are
+var stopEvents func()
+
+func eventTypes() string {
+	var res []string
+	for _, t := range spacetraders.AllEvents {
+		res = append(res, string(t))
+	}
+	return strings.Join(res, ", ")
+}
+
+// Find event types by a case insensitive prefix
+func parseEventTypes(names []string) ([]spacetraders.EventType, error) {
+	var res []spacetraders.EventType
+	for _, n := range names {
+		var matches []spacetraders.EventType
+		for _, t := range spacetraders.AllEvents {
+			if strings.HasPrefix(strings.ToLower(string(t)), strings.ToLower(n)) {
+				matches = append(matches, t)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("unknown event type %q, must be one of %s", n, eventTypes())
+		case 1:
+			res = append(res, matches[0])
+		default:
+			return nil, fmt.Errorf("%q could mean %v", n, matches)
+		}
+	}
+	return res, nil
+}
+
+func doEvents(c *spacetraders.Client, args []string) error {
+	mode := ""
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "follow", "stop":
+			mode = strings.ToLower(args[0])
+			args = args[1:]
+		}
+	}
+	types, err := parseEventTypes(args)
+	if err != nil {
+		return err
+	}
+
+	bus := spacetraders.GetEventBus()
+	switch mode {
+	case "stop":
+		if stopEvents == nil {
+			return fmt.Errorf("not following events")
+		}
+		stopEvents()
+		stopEvents = nil
+		Out("Stopped following events")
+		return nil
+	case "follow":
+		if stopEvents != nil {
+			stopEvents()
+		}
+		stopEvents = bus.Subscribe(func(e spacetraders.Event) {
+			ui.Msg("%s", e)
+		}, types...)
+		Out("Following events in the messages window, use 'Events stop' to stop")
+		return nil
+	}
+
+	recent := bus.Recent(types...)
+	if len(recent) == 0 {
+		Out("No events yet.")
+		return nil
+	}
+	if len(recent) > eventsShown {
+		recent = recent[len(recent)-eventsShown:]
+	}
+	for _, e := range recent {
+		Out(e.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Automation",
+			Name:    "ExportRoute",
+			Usage:   "ExportRoute <route name> <path/to/file>",
+			Help: "Write a route's stops, cargo and rules to a file that can be shared. " +
+				"Ships and trading history aren't included.",
+			Do:      doExportRoute,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+		{
+			Section: "Automation",
+			Name:    "ImportRoute",
+			Usage:   "ImportRoute <path/to/file> [route name]",
+			Help: "Create a route from a file written by ExportRoute, optionally with a " +
+				"different name. Locations and goods are checked before the route is created, " +
+				"and it won't be imported if it can't work unless -force is given.",
+			Do:      doImportRoute,
+			MinArgs: 1,
+			MaxArgs: 2,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+// The current version of the route file format
+const routeFileVersion = 1
+
+// A route as written to a file. Cargo uses the same syntax as
+// CreateTradeRoute, and rules the same as SetRouteRules.
+type routeFile struct {
+	Version  int         `json:"version"`
+	Name     string      `json:"name"`
+	AutoFuel bool        `json:"autoFuel"`
+	Budget   int         `json:"budget,omitempty"`
+	Stops    []routeStop `json:"stops"`
+}
+
+type routeStop struct {
+	Location string `json:"location"`
+	Cargo    string `json:"cargo"`
+	Rules    string `json:"rules,omitempty"`
+}
+
+func (r *route) Export() *routeFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &routeFile{
+		Version:  routeFileVersion,
+		Name:     r.Name,
+		AutoFuel: r.AutoFuel,
+		Budget:   r.Budget,
+	}
+	for i := range r.Destinations {
+		f.Stops = append(f.Stops, routeStop{
+			Location: r.Destinations[i],
+			Cargo:    r.Cargos[i].String(),
+			Rules:    r.Rules[i].String(),
+		})
+	}
+	return f
+}
+
+func doExportRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r.Export(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't encode route %s: %v", r.Name, err)
+	}
+	if err := ioutil.WriteFile(args[1], append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("can't write %q: %v", args[1], err)
+	}
+	Out("Exported %s to %s", r.Name, args[1])
+
+	return nil
+}
+
+// Read and check a route file written by ExportRoute. The stops' locations
+// and goods are checked when the route is created.
+func readRouteFile(path string) (*routeFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %q: %v", path, err)
+	}
+
+	// Same default as new routes, if it's not in the file
+	f := &routeFile{AutoFuel: true}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(f); err != nil {
+		return nil, fmt.Errorf("can't decode route from %q: %v", path, err)
+	}
+	if f.Version != routeFileVersion {
+		return nil, fmt.Errorf("unsupported route file version %d", f.Version)
+	}
+	if len(f.Stops) == 0 {
+		return nil, fmt.Errorf("route in %q has no stops", path)
+	}
+	if f.Budget < 0 {
+		return nil, fmt.Errorf("invalid budget %d in %q", f.Budget, path)
+	}
+
+	return f, nil
+}
+
+func doImportRoute(c *spacetraders.Client, args []string) error {
+	f, err := readRouteFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	name := f.Name
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if name == "" {
+		return fmt.Errorf("route in %q has no name, give one to import it", args[0])
+	}
+	if err := checkNewRouteName(name); err != nil {
+		return err
+	}
+
+	r := newRoute(name)
+	r.AutoFuel = f.AutoFuel
+	r.Budget = f.Budget
+	for i, s := range f.Stops {
+		loc, cargo, err := parseStop(c, s.Location, s.Cargo)
+		if err != nil {
+			return fmt.Errorf("invalid stop %d (%s %s): %v", i+1, s.Location, s.Cargo, err)
+		}
+		var rules tradeRules
+		if err := rules.Parse(strings.Fields(s.Rules)); err != nil {
+			return fmt.Errorf("invalid rules for stop %d: %v", i+1, err)
+		}
+		r.AddStop(loc, cargo, rules)
+	}
+	if err := reportRoute(c, r, nil); err != nil {
+		return err
+	}
+	if err := addRoute(r); err != nil {
+		return err
+	}
+	Out("Imported route:\n%s", r.String())
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportRoute(t *testing.T) {
+	r := newRoute("metals")
+	r.AddStop("OE-PM-TR", simpleCargo("METALS"), tradeRules{MaxBuy: 10})
+	r.AddStop("OE-PM", simpleCargo("NONE"), tradeRules{MinMargin: 20, Hold: true})
+	r.AddShip("ship1")
+	r.Balance = 100
+
+	data, err := json.Marshal(r.Export())
+	if err != nil {
+		t.Fatalf("can't marshal: %v", err)
+	}
+	var got routeFile
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("can't unmarshal: %v", err)
+	}
+
+	want := routeFile{
+		Version:  routeFileVersion,
+		Name:     "metals",
+		AutoFuel: true,
+		Stops: []routeStop{
+			{Location: "OE-PM-TR", Cargo: "METALS", Rules: "maxbuy=10"},
+			{Location: "OE-PM", Cargo: "NONE", Rules: "margin=20 hold=true"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got\n%s", diff)
+	}
+}
+
+func TestReadRouteFile(t *testing.T) {
+	r := newRoute("export-import")
+	r.AddStop("OE-PM-TR", simpleCargo("METALS"), tradeRules{MaxBuy: 10})
+	r.AddStop("OE-PM", simpleCargo("NONE"), tradeRules{MinMargin: 20, Hold: true})
+	r.Budget = 5000
+	if err := addRoute(r); err != nil {
+		t.Fatalf("can't add route: %v", err)
+	}
+	defer deleteRoute(r.Name)
+
+	dir := t.TempDir()
+	exported := filepath.Join(dir, "route.json")
+	if err := doExportRoute(nil, []string{r.Name, exported}); err != nil {
+		t.Fatalf("can't export: %v", err)
+	}
+	got, err := readRouteFile(exported)
+	if err != nil {
+		t.Fatalf("can't read exported route: %v", err)
+	}
+	if diff := cmp.Diff(r.Export(), got); diff != "" {
+		t.Errorf("bad round trip: -want +got\n%s", diff)
+	}
+
+	tests := []struct {
+		desc string
+		data string
+	}{
+		{desc: "malformed", data: `{"version": 1, "name": "bad",`},
+		{desc: "unknown field", data: `{"version": 1, "name": "bad", "ships": ["s1"], "stops": [{"location": "OE-PM"}]}`},
+		{desc: "unsupported version", data: `{"version": 2, "name": "bad", "stops": [{"location": "OE-PM"}]}`},
+		{desc: "no stops", data: `{"version": 1, "name": "bad"}`},
+		{desc: "negative budget", data: `{"version": 1, "name": "bad", "budget": -10, "stops": [{"location": "OE-PM"}]}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(dir, "bad.json")
+			if err := ioutil.WriteFile(path, []byte(tc.data), 0644); err != nil {
+				t.Fatalf("can't write: %v", err)
+			}
+			if f, err := readRouteFile(path); err == nil {
+				t.Errorf("want an error, got %+v", f)
+			}
+		})
+	}
+	if _, err := readRouteFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("read a missing file")
+	}
+}
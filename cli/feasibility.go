@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+// Something that would stop a route from working. Hard problems mean the
+// route can't work at all.
+type routeProblem struct {
+	Stop int
+	Loc  string
+	Hard bool
+	Msg  string
+}
+
+func (p routeProblem) String() string {
+	level := "warning"
+	if p.Hard {
+		level = "ERROR"
+	}
+	return fmt.Sprintf("%s: stop %d (%s): %s", level, p.Stop+1, p.Loc, p.Msg)
+}
+
+// Check that a route can work as a loop, using the markets we know about.
+// With a ship, also check that it can carry the fuel and cargo for each leg.
+// market returns nil for locations we don't have data for.
+func checkRoute(r *route, ship *spacetraders.Ship, locs map[string]*spacetraders.Location, market func(string) []spacetraders.Offer) []routeProblem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []routeProblem
+	n := len(r.Destinations)
+	problem := func(stop int, hard bool, format string, args ...interface{}) {
+		res = append(res, routeProblem{
+			Stop: stop,
+			Loc:  r.Destinations[stop],
+			Hard: hard,
+			Msg:  fmt.Sprintf(format, args...),
+		})
+	}
+	// Fuel bought as cargo can get a ship past stops that don't sell it
+	carriesFuel := false
+	for _, c := range r.Cargos {
+		for _, b := range c.Buy {
+			carriesFuel = carriesFuel || b.Good == "FUEL"
+		}
+	}
+	need, onBoard := fuelOnArrival(r, ship, locs, market)
+
+	for i := 0; i < n; i++ {
+		next := r.Destinations[(i+1)%n]
+		offers := market(r.Destinations[i])
+		if offers == nil {
+			problem(i, false, "no market data, can't check fuel or cargo")
+		} else if !trades(offers, "FUEL") {
+			switch {
+			case onBoard == nil && !carriesFuel:
+				problem(i, true, "no fuel for sale, needed to get to %s", next)
+			case onBoard == nil:
+				problem(i, false, "no fuel for sale, fuel from an earlier stop is needed to get to %s", next)
+			case onBoard[i] < need[i]:
+				problem(i, true, "no fuel for sale, and only %d of the %d fuel needed to get to %s is on board",
+					onBoard[i], need[i], next)
+			}
+		}
+
+		if ship != nil {
+			from, to := locs[r.Destinations[i]], locs[next]
+			if from == nil || to == nil {
+				problem(i, true, "unknown location on the way to %s", next)
+			} else if need := ship.FuelNeeded(from, to); need > ship.MaxCargo {
+				problem(i, true, "%s needs %d fuel to get to %s, but can only carry %d", ship.Type, need, next, ship.MaxCargo)
+			} else if need == ship.MaxCargo && len(r.Cargos[i].Buy) > 0 {
+				problem(i, false, "%s has no room for cargo after fuel to %s", ship.Type, next)
+			}
+		}
+
+		for _, b := range r.Cargos[i].Buy {
+			if ship != nil && len(ship.RestrictedGoods) > 0 && b.Good != "FUEL" {
+				allowed := false
+				for _, g := range ship.RestrictedGoods {
+					if g == b.Good {
+						allowed = true
+					}
+				}
+				if !allowed {
+					problem(i, true, "%s can only carry %s, not %s", ship.Type, strings.Join(ship.RestrictedGoods, ", "), b.Good)
+				}
+			}
+			if offers != nil && !trades(offers, b.Good) {
+				problem(i, false, "%s isn't for sale", b.Good)
+			}
+
+			// Find where it'll be sold, the stop itself is the last chance.
+			// Fuel is burned rather than sold.
+			sold := b.Good == "FUEL"
+			for j := 1; j <= n && !sold; j++ {
+				stop := (i + j) % n
+				if !r.Cargos[stop].Sells(b.Good) {
+					continue
+				}
+				sold = true
+				if m := market(r.Destinations[stop]); m != nil && !trades(m, b.Good) {
+					problem(i, true, "%s is sold at %s, but isn't traded there", b.Good, r.Destinations[stop])
+				}
+			}
+			if !sold {
+				problem(i, true, "%s is never sold", b.Good)
+			}
+		}
+	}
+
+	return res
+}
+
+func trades(offers []spacetraders.Offer, good string) bool {
+	for _, o := range offers {
+		if o.Symbol == good {
+			return true
+		}
+	}
+	return false
+}
+
+// Work out the fuel a ship needs for each leg of the route, and how much it
+// has on board when it gets to each stop once it's been around the loop. The
+// route buys fuel for the next leg where it's sold, and the stops' cargo can
+// add more. Returns nil without a ship, or if a location isn't known. r.mu
+// must be held.
+func fuelOnArrival(r *route, ship *spacetraders.Ship, locs map[string]*spacetraders.Location, market func(string) []spacetraders.Offer) ([]int, []int) {
+	if ship == nil {
+		return nil, nil
+	}
+	n := len(r.Destinations)
+	need := make([]int, n)
+	for i := range r.Destinations {
+		from, to := locs[r.Destinations[i]], locs[r.Destinations[(i+1)%n]]
+		if from == nil || to == nil {
+			return nil, nil
+		}
+		need[i] = ship.FuelNeeded(from, to)
+	}
+
+	onBoard := make([]int, n)
+	fuel := 0
+	// The second time around starts with what's left from the first
+	for loop := 0; loop < 2; loop++ {
+		for i := range r.Destinations {
+			onBoard[i] = fuel
+			offers := market(r.Destinations[i])
+			if offers != nil && !trades(offers, "FUEL") {
+				fuel -= need[i]
+				if fuel < 0 {
+					fuel = 0
+				}
+				continue
+			}
+			if fuel < need[i] {
+				fuel = need[i]
+			}
+			space := ship.MaxCargo - fuel
+			for _, b := range r.Cargos[i].Buy {
+				room := space
+				if b.Percent > 0 {
+					room = space * b.Percent / 100
+				}
+				if b.Good == "FUEL" {
+					fuel += room
+				}
+				space -= room
+			}
+			fuel -= need[i]
+		}
+	}
+
+	return need, onBoard
+}
+
+// Print the problems with a route, and refuse if it can't work unless the
+// command was forced.
+func reportRoute(c *spacetraders.Client, r *route, ship *spacetraders.Ship) error {
+	locs := make(map[string]*spacetraders.Location)
+	if ship != nil {
+		for _, d := range r.Destinations {
+			l, err := getLocation(c, d)
+			if err != nil {
+				return fmt.Errorf("can't find location %q: %v", d, err)
+			}
+			locs[d] = l
+		}
+	}
+	mh := spacetraders.GetMarketHistory()
+	market := func(loc string) []spacetraders.Offer {
+		if s := mh.Latest(loc); s != nil {
+			return s.Offers
+		}
+		return nil
+	}
+
+	problems := checkRoute(r, ship, locs, market)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	hard := false
+	Out("Problems with route %s:", r.Name)
+	for _, p := range problems {
+		Out("  %s", p)
+		hard = hard || p.Hard
+	}
+	if hard && !forced {
+		return fmt.Errorf("route %s can't work, use -force to go ahead anyway", r.Name)
+	}
+
+	return nil
+}
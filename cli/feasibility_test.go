@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestCheckRoute(t *testing.T) {
+	locs := map[string]*spacetraders.Location{
+		"OE-A": {Symbol: "OE-A", Type: "MOON", X: 0, Y: 0},
+		"OE-B": {Symbol: "OE-B", Type: "MOON", X: 10, Y: 0},
+		"OE-C": {Symbol: "OE-C", Type: "MOON", X: 0, Y: 10},
+		"OE-Z": {Symbol: "OE-Z", Type: "MOON", X: 5000, Y: 5000},
+	}
+	offer := func(good string) spacetraders.Offer { return spacetraders.Offer{Symbol: good} }
+	markets := map[string][]spacetraders.Offer{
+		"OE-A": {offer("FUEL"), offer("METALS")},
+		"OE-B": {offer("FUEL"), offer("METALS"), offer("DRONES")},
+		"OE-C": {offer("DRONES")},
+		"OE-Z": {offer("FUEL"), offer("METALS")},
+	}
+	market := func(loc string) []spacetraders.Offer { return markets[loc] }
+	ship := &spacetraders.Ship{Type: "JW-MK-I", MaxCargo: 50, Speed: 1}
+	restricted := &spacetraders.Ship{Type: "MINER", MaxCargo: 50, Speed: 1, RestrictedGoods: []string{"METALS"}}
+	backFromC := ship.FuelNeeded(locs["OE-C"], locs["OE-A"])
+
+	type stop struct{ loc, cargo string }
+	tests := []struct {
+		desc  string
+		stops []stop
+		ship  *spacetraders.Ship
+		want  []string
+	}{
+		{
+			desc:  "fine",
+			stops: []stop{{"OE-A", "METALS"}, {"OE-B", "NONE"}},
+			ship:  ship,
+		},
+		{
+			desc:  "no fuel",
+			stops: []stop{{"OE-A", "METALS"}, {"OE-C", "NONE"}},
+			want: []string{
+				"ERROR: stop 1 (OE-A): METALS is sold at OE-C, but isn't traded there",
+				"ERROR: stop 2 (OE-C): no fuel for sale, needed to get to OE-A",
+			},
+		},
+		{
+			desc:  "no fuel with a ship",
+			stops: []stop{{"OE-A", "METALS"}, {"OE-C", "NONE"}},
+			ship:  ship,
+			want: []string{
+				"ERROR: stop 1 (OE-A): METALS is sold at OE-C, but isn't traded there",
+				fmt.Sprintf("ERROR: stop 2 (OE-C): no fuel for sale, and only 0 of the %d fuel needed to get to OE-A is on board", backFromC),
+			},
+		},
+		{
+			desc:  "fuel carried",
+			stops: []stop{{"OE-A", "FUEL:50"}, {"OE-C", "NONE"}},
+			ship:  ship,
+		},
+		{
+			desc:  "fuel carried without a ship",
+			stops: []stop{{"OE-A", "FUEL:50"}, {"OE-C", "NONE"}},
+			want:  []string{"warning: stop 2 (OE-C): no fuel for sale, fuel from an earlier stop is needed to get to OE-A"},
+		},
+		{
+			desc:  "kept for a later stop",
+			stops: []stop{{"OE-A", "METALS/NONE"}, {"OE-C", "NONE/NONE"}, {"OE-B", "NONE"}},
+			want:  []string{"ERROR: stop 2 (OE-C): no fuel for sale, needed to get to OE-B"},
+		},
+		{
+			desc:  "never sold",
+			stops: []stop{{"OE-A", "METALS/NONE"}, {"OE-B", "NONE/DRONES"}},
+			want:  []string{"ERROR: stop 1 (OE-A): METALS is never sold"},
+		},
+		{
+			desc:  "too far",
+			stops: []stop{{"OE-A", "NONE"}, {"OE-Z", "NONE"}},
+			ship:  ship,
+			want: []string{
+				"ERROR: stop 1 (OE-A): JW-MK-I needs 944 fuel to get to OE-Z, but can only carry 50",
+				"ERROR: stop 2 (OE-Z): JW-MK-I needs 944 fuel to get to OE-A, but can only carry 50",
+			},
+		},
+		{
+			desc:  "restricted",
+			stops: []stop{{"OE-B", "DRONES"}, {"OE-A", "NONE"}},
+			ship:  restricted,
+			want: []string{
+				"ERROR: stop 1 (OE-B): MINER can only carry METALS, not DRONES",
+				"ERROR: stop 1 (OE-B): DRONES is sold at OE-A, but isn't traded there",
+			},
+		},
+		{
+			desc:  "unknown market",
+			stops: []stop{{"OE-A", "METALS"}, {"OE-X", "NONE"}},
+			want:  []string{"warning: stop 2 (OE-X): no market data, can't check fuel or cargo"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRoute("test")
+			for _, s := range tc.stops {
+				cargo, err := parseStopCargo(s.cargo)
+				if err != nil {
+					t.Fatalf("bad cargo %q: %v", s.cargo, err)
+				}
+				r.AddStop(s.loc, cargo, tradeRules{})
+			}
+			var got []string
+			for _, p := range checkRoute(r, tc.ship, locs, market) {
+				got = append(got, p.String())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("-want +got\n%s", diff)
+			}
+		})
+	}
+}
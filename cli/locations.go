@@ -126,6 +126,29 @@ func getLocation(c *spacetraders.Client, loc string) (*spacetraders.Location, er
 	return nil, fmt.Errorf("can't find location %q in %q!", loc, sysName)
 }
 
+// All the locations in a system
+func systemLocations(c *spacetraders.Client, system string) ([]*spacetraders.Location, error) {
+	systems, err := c.ListSystems()
+	if err != nil {
+		return nil, fmt.Errorf("can't load systems: %v", err)
+	}
+
+	var locs []*spacetraders.Location
+	for _, s := range systems {
+		if s.Symbol != strings.ToUpper(system) {
+			continue
+		}
+		for i := range s.Locations {
+			locs = append(locs, &s.Locations[i])
+		}
+	}
+	if len(locs) == 0 {
+		return nil, fmt.Errorf("no locations found in %q", system)
+	}
+
+	return locs, nil
+}
+
 func doDistance(c *spacetraders.Client, args []string) error {
 	loc1, err := getLocation(c, args[0])
 	if err != nil {
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+// A client for a fake API server that answers with the responses by path.
+// Returns the client and a function that counts the calls made so far.
+func fakeAPI(t *testing.T, responses map[string]interface{}) (*spacetraders.Client, func() int) {
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(srv.Close)
+	c := spacetraders.New()
+	c.SetServer(srv.URL)
+
+	return c, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+var fakeSystems = spacetraders.SystemsRes{Systems: []spacetraders.System{
+	{Symbol: "OE", Locations: []spacetraders.Location{
+		{Symbol: "OE-PM", Type: "PLANET"},
+		{Symbol: "OE-PM-TR", Type: "MOON", X: 10},
+	}},
+	{Symbol: "XV", Locations: []spacetraders.Location{
+		{Symbol: "XV-BN", Type: "PLANET"},
+	}},
+}}
+
+func TestGetLocation(t *testing.T) {
+	c, _ := fakeAPI(t, map[string]interface{}{"/game/systems": fakeSystems})
+
+	l, err := getLocation(c, "oe-pm-tr")
+	if err != nil {
+		t.Fatalf("can't get location: %v", err)
+	}
+	if l.Symbol != "OE-PM-TR" || l.SystemSymbol != "OE" {
+		t.Errorf("bad location: %+v", l)
+	}
+
+	locs, err := systemLocations(c, l.SystemSymbol)
+	if err != nil {
+		t.Fatalf("can't list locations in %q: %v", l.SystemSymbol, err)
+	}
+	var got []string
+	for _, l := range locs {
+		got = append(got, l.Symbol+" in "+l.SystemSymbol)
+	}
+	if diff := cmp.Diff([]string{"OE-PM in OE", "OE-PM-TR in OE"}, got); diff != "" {
+		t.Errorf("bad locations: -want +got\n%s", diff)
+	}
+
+	if _, err := getLocation(c, "OE-NOWHERE"); err == nil {
+		t.Errorf("found an unknown location")
+	}
+}
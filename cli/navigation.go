@@ -0,0 +1,341 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+	"github.com/zigdon/spacetraders/tasks"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Flight Plans",
+			Name:    "FuelModel",
+			Usage:   "FuelModel [ship type]",
+			Help:    "Show the fuel table, and the fuel use and travel times learned from past flights",
+			Do:      doFuelModel,
+			MaxArgs: 1,
+		},
+		{
+			Section:    "Flight Plans",
+			Name:       "Eta",
+			Usage:      "Eta <ship> <destination>",
+			Validators: []string{"ship", "location"},
+			Help:       "Estimate the fuel and time a flight would take, without creating it",
+			Do:         doEta,
+			MinArgs:    2,
+			MaxArgs:    2,
+		},
+		{
+			Section:    "Flight Plans",
+			Name:       "Plan",
+			Usage:      "Plan <ship> <destination>...",
+			Validators: []string{"ship"},
+			Help: "Simulate flying a ship through each destination in turn, refuelling " +
+				"where fuel is known to be sold, and print the itinerary. No flights are created.",
+			Do:      doPlan,
+			MinArgs: 2,
+			MaxArgs: -1,
+		},
+		{
+			Section:    "Flight Plans",
+			Name:       "GoVia",
+			Usage:      "GoVia <ship> <destination>",
+			Validators: []string{"ship", "location"},
+			Help: "Fly a ship to a destination it can't reach directly, stopping to " +
+				"refuel at locations that sell fuel. Progress is reported in the msgs window.",
+			Do:      doGoVia,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+
+	tm := spacetraders.GetTravelModel()
+	if err := RegisterPersistence("travel", tm.Save, tm.Load); err != nil {
+		log.Fatalf("Can't register load/save for travel model: %v", err)
+	}
+
+	if err := tasks.RegisterKind("govia", journeyTask); err != nil {
+		log.Fatalf("Can't register govia tasks: %v", err)
+	}
+
+	aw := spacetraders.GetArrivalWatcher()
+	if err := RegisterPersistence("arrivals", aw.Save, aw.Load); err != nil {
+		log.Fatalf("Can't register load/save for arrivals: %v", err)
+	}
+	spacetraders.GetEventBus().Subscribe(func(e spacetraders.Event) {
+		if ui == nil {
+			return
+		}
+		ui.Msg("%s", e.Summary())
+	}, spacetraders.ShipArrived)
+
+	fm := spacetraders.GetFuelModel()
+	if err := RegisterPersistence("fuel", fm.Save, fm.Load); err != nil {
+		log.Fatalf("Can't register load/save for fuel model: %v", err)
+	}
+	fm.OnDiverge = func(shipType, destType string, dist float64, predicted, actual int) {
+		if ui == nil {
+			return
+		}
+		ui.Msg("Fuel model: %s to %s (distance %.1f) used %d fuel, predicted %d",
+			shipType, destType, dist, actual, predicted)
+	}
+}
+
+func doFuelModel(c *spacetraders.Client, args []string) error {
+	shipType := ""
+	if len(args) > 0 {
+		shipType = args[0]
+	}
+	Out(spacetraders.GetFuelModel().String(shipType))
+	Out(spacetraders.GetTravelModel().String())
+
+	return nil
+}
+
+// How much fuel a ship is carrying
+func fuelOnBoard(ship *spacetraders.Ship) int {
+	for _, c := range ship.Cargo {
+		if c.Good == "FUEL" {
+			return c.Quantity
+		}
+	}
+	return 0
+}
+
+func doEta(c *spacetraders.Client, args []string) error {
+	ship, err := getShip(c, args[0])
+	if err != nil {
+		return fmt.Errorf("can't find ship %q: %v", args[0], err)
+	}
+	if ship.FlightPlanID != "" {
+		return fmt.Errorf("%s is in flight to %s", ship.ShortID, ship.FlightPlanDest)
+	}
+
+	src, err := getLocation(c, ship.LocationName)
+	if err != nil {
+		return fmt.Errorf("can't find location %q: %v", ship.LocationName, err)
+	}
+	dest, err := getLocation(c, args[1])
+	if err != nil {
+		return fmt.Errorf("can't find location %q: %v", args[1], err)
+	}
+
+	fuel := ship.FuelNeeded(src, dest)
+	have := fuelOnBoard(ship)
+	flight := ship.FlightTime(src, dest)
+	Out("%s: %s -> %s, distance: %.2f", ship.ShortID, src.Symbol, dest.Symbol, src.Distance(dest))
+	if have >= fuel {
+		Out("  Fuel needed: %d (have %d)", fuel, have)
+	} else {
+		Out("  Fuel needed: %d (have %d, need to buy %d)", fuel, have, fuel-have)
+	}
+	Out("  Flight time: %s, arriving at %s", flight, time.Now().Add(flight).Local().Format("15:04:05"))
+
+	return nil
+}
+
+// Does the last recorded market at a location sell fuel?
+func sellsFuel(loc string) bool {
+	m := spacetraders.GetMarketHistory().Latest(loc)
+	return m != nil && m.Offer("FUEL") != nil
+}
+
+func doPlan(c *spacetraders.Client, args []string) error {
+	ship, err := getShip(c, args[0])
+	if err != nil {
+		return fmt.Errorf("can't find ship %q: %v", args[0], err)
+	}
+
+	dests := args[1:]
+	validators := []string{}
+	for range dests {
+		validators = append(validators, "location")
+	}
+	if err := validate(c, dests, validators); err != nil {
+		return fmt.Errorf("invalid destinations: %v", err)
+	}
+
+	start := ship.LocationName
+	now := time.Now()
+	if ship.FlightPlanID != "" {
+		fp, err := c.ShowFlight(ship.FlightPlanID)
+		if err != nil {
+			return fmt.Errorf("can't look up flight %s: %v", ship.ShortFlightPlanID, err)
+		}
+		start = fp.Destination
+		if fp.ArrivesAt.After(now) {
+			now = fp.ArrivesAt
+		}
+	}
+	src, err := getLocation(c, start)
+	if err != nil {
+		return fmt.Errorf("can't find location %q: %v", start, err)
+	}
+
+	var locs []*spacetraders.Location
+	for _, d := range dests {
+		l, err := getLocation(c, d)
+		if err != nil {
+			return fmt.Errorf("can't find location %q: %v", d, err)
+		}
+		locs = append(locs, l)
+	}
+
+	hops := ship.PlanHops(src, fuelOnBoard(ship), locs, sellsFuel, now)
+	Out("Itinerary for %s (%s) from %s:", ship.ShortID, ship.Type, src.Symbol)
+	Out(spacetraders.Itinerary(hops))
+
+	bad := 0
+	for _, h := range hops {
+		if h.Problem != "" {
+			bad++
+		}
+	}
+	if bad > 0 {
+		Out("%d of %d legs are not feasible.", bad, len(hops))
+	} else if len(hops) > 0 {
+		Out("All legs feasible, arriving at %s in %s.",
+			hops[len(hops)-1].To.Symbol, hops[len(hops)-1].Arrives.Sub(time.Now()).Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// A multi-hop flight in progress
+type journey struct {
+	shipID string
+	short  string
+	path   []string
+	hop    int
+	tries  int
+}
+
+func doGoVia(c *spacetraders.Client, args []string) error {
+	ship, err := getShip(c, args[0])
+	if err != nil {
+		return fmt.Errorf("can't find ship %q: %v", args[0], err)
+	}
+	if ship.FlightPlanID != "" {
+		return fmt.Errorf("%s is in flight to %s", ship.ShortID, ship.FlightPlanDest)
+	}
+
+	src, err := getLocation(c, ship.LocationName)
+	if err != nil {
+		return fmt.Errorf("can't find location %q: %v", ship.LocationName, err)
+	}
+	dest, err := getLocation(c, args[1])
+	if err != nil {
+		return fmt.Errorf("can't find location %q: %v", args[1], err)
+	}
+	if src.Symbol == dest.Symbol {
+		return fmt.Errorf("%s is already at %s", ship.ShortID, dest.Symbol)
+	}
+
+	locs, err := systemLocations(c, src.SystemSymbol)
+	if err != nil {
+		return err
+	}
+	path, err := ship.FindPath(src, dest, locs, fuelOnBoard(ship), sellsFuel)
+	if err != nil {
+		return err
+	}
+
+	j := &journey{shipID: ship.ID, short: ship.ShortID, path: []string{src.Symbol}}
+	for _, l := range path {
+		j.path = append(j.path, l.Symbol)
+	}
+	Out("%s: flying %s", ship.ShortID, strings.Join(j.path, " -> "))
+
+	return j.step(c)
+}
+
+// Refuel and fly the next hop of the journey, and schedule the one after
+func (j *journey) step(c *spacetraders.Client) error {
+	ship, err := getShip(c, j.shipID)
+	if err != nil {
+		return fmt.Errorf("can't find ship %s: %v", j.short, err)
+	}
+
+	// Not there yet, check again in a bit
+	if ship.FlightPlanID != "" {
+		j.tries++
+		return j.schedule(fmt.Sprintf("govia:%s:%d:%d", j.short, j.hop, j.tries), time.Now().Add(10*time.Second))
+	}
+
+	if ship.LocationName != j.path[j.hop] {
+		ui.Msg("%s: expected to be at %s, but is at %s. Stopping.", j.short, j.path[j.hop], ship.LocationName)
+		return fmt.Errorf("%s is off course at %s", j.short, ship.LocationName)
+	}
+
+	if j.hop == len(j.path)-1 {
+		ui.Msg("%s: arrived at %s", j.short, ship.LocationName)
+		return nil
+	}
+
+	src, err := getLocation(c, j.path[j.hop])
+	if err != nil {
+		return err
+	}
+	dest, err := getLocation(c, j.path[j.hop+1])
+	if err != nil {
+		return err
+	}
+
+	need := ship.FuelNeeded(src, dest) - fuelOnBoard(ship)
+	for need > 0 {
+		qty := need
+		if qty > ship.LoadingSpeed {
+			qty = ship.LoadingSpeed
+		}
+		o, err := c.BuyCargo(ship.ID, "FUEL", qty)
+		if err != nil {
+			ui.Msg("%s: can't buy fuel at %s: %v", j.short, src.Symbol, err)
+			return fmt.Errorf("%s can't buy fuel at %s: %v", j.short, src.Symbol, err)
+		}
+		need -= o.Quantity
+		ui.Msg("%s: bought %d fuel at %s", j.short, o.Quantity, src.Symbol)
+	}
+
+	fp, err := c.CreateFlight(ship.ID, dest.Symbol)
+	if err != nil {
+		ui.Msg("%s: can't fly to %s: %v", j.short, dest.Symbol, err)
+		return fmt.Errorf("%s can't fly to %s: %v", j.short, dest.Symbol, err)
+	}
+	j.hop++
+	j.tries = 0
+	ui.Msg("%s: hop %d/%d, %s -> %s, arriving in %s", j.short, j.hop, len(j.path)-1,
+		src.Symbol, dest.Symbol, fp.ArrivesAt.Sub(time.Now()).Truncate(time.Second))
+
+	return j.schedule(fmt.Sprintf("govia:%s:%d", j.short, j.hop), fp.ArrivesAt)
+}
+
+// Take the next step of the journey later. The journey is saved with the task,
+// so it carries on after a restart.
+func (j *journey) schedule(key string, when time.Time) error {
+	args := append([]string{j.shipID, j.short, strconv.Itoa(j.hop)}, j.path...)
+	return tasks.GetTaskQueue().AddKind(key, "", when, tasks.Schedule{}, "govia", args...)
+}
+
+// Re-create a journey from the arguments saved by schedule
+func journeyTask(args []string) (func(*spacetraders.Client) error, error) {
+	if len(args) < 5 {
+		return nil, fmt.Errorf("need a ship, hop and path, got %q", args)
+	}
+	hop, err := strconv.Atoi(args[2])
+	if err != nil || hop < 0 || hop >= len(args)-3 {
+		return nil, fmt.Errorf("invalid hop %q", args[2])
+	}
+	j := &journey{shipID: args[0], short: args[1], hop: hop, path: args[3:]}
+	return j.step, nil
+}
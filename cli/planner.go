@@ -0,0 +1,284 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section:    "Automation",
+			Name:       "PlanRoute",
+			Usage:      "PlanRoute <system> <ship type|ship id> [stops] [route name]",
+			Validators: []string{"system"},
+			Help: "Search for the most profitable cyclic trade route of 2 up to [stops] " +
+				"(default 3) locations, using recorded market prices. If a route name is " +
+				"given, the best route is created, and if a ship id was given, the ship " +
+				"is added to it. Use -force to create a route even if it can't work.",
+			Do:      doPlanRoute,
+			MinArgs: 2,
+			MaxArgs: 4,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+
+	mh := spacetraders.GetMarketHistory()
+	if err := RegisterPersistence("markets", mh.Save, mh.Load); err != nil {
+		log.Fatalf("Can't register load/save for markets: %v", err)
+	}
+}
+
+const maxPlanStops = 5
+
+type plannedLeg struct {
+	From   *spacetraders.Location
+	To     *spacetraders.Location
+	Good   string
+	Qty    int
+	Fuel   int
+	Profit int
+	Time   time.Duration
+}
+
+type plannedRoute struct {
+	Legs   []plannedLeg
+	Profit int
+	Time   time.Duration
+}
+
+func (p *plannedRoute) PerHour() float64 {
+	if p.Time <= 0 {
+		return 0
+	}
+	return float64(p.Profit) / p.Time.Hours()
+}
+
+func (p *plannedRoute) String() string {
+	res := []string{fmt.Sprintf("%.0f credits/hour (%d credits per %s loop)",
+		p.PerHour(), p.Profit, p.Time.Truncate(time.Second))}
+	for _, l := range p.Legs {
+		res = append(res, fmt.Sprintf("  %s -> %s: %d x %s, fuel: %d, profit: %d, time: %s",
+			l.From.Symbol, l.To.Symbol, l.Qty, l.Good, l.Fuel, l.Profit, l.Time))
+	}
+	return strings.Join(res, "\n")
+}
+
+// Work out the best cargo to carry between two locations, given their markets
+func planLeg(ship *spacetraders.Ship, from, to *spacetraders.Location, src, dest []spacetraders.Offer) (*plannedLeg, error) {
+	leg := &plannedLeg{
+		From: from,
+		To:   to,
+		Good: "NONE",
+		Fuel: ship.FuelNeeded(from, to),
+		Time: ship.FlightTime(from, to),
+	}
+	if leg.Fuel > ship.MaxCargo {
+		return nil, fmt.Errorf("%s can't carry %d fuel for %s->%s", ship.Type, leg.Fuel, from.Symbol, to.Symbol)
+	}
+
+	sells := make(map[string]spacetraders.Offer)
+	for _, o := range dest {
+		sells[o.Symbol] = o
+	}
+	fuelPrice := -1
+	for _, o := range src {
+		if o.Symbol == "FUEL" {
+			fuelPrice = o.PurchasePricePerUnit
+		}
+	}
+	if fuelPrice < 0 {
+		return nil, fmt.Errorf("no fuel for sale at %s", from.Symbol)
+	}
+
+	space := ship.MaxCargo - leg.Fuel
+	for _, o := range src {
+		sell, ok := sells[o.Symbol]
+		if o.Symbol == "FUEL" || !ok || o.VolumePerUnit == 0 {
+			continue
+		}
+		qty := space / o.VolumePerUnit
+		if qty > o.QuantityAvailable {
+			qty = o.QuantityAvailable
+		}
+		profit := qty * (sell.SellPricePerUnit - o.PurchasePricePerUnit)
+		if profit > leg.Profit {
+			leg.Good = o.Symbol
+			leg.Qty = qty
+			leg.Profit = profit
+		}
+	}
+	leg.Profit -= leg.Fuel * fuelPrice
+
+	return leg, nil
+}
+
+// Search all the loops of 2 to maxStops locations, and return the feasible
+// ones sorted by credits per hour.
+func planRoutes(ship *spacetraders.Ship, locs []*spacetraders.Location, offers map[string][]spacetraders.Offer, maxStops int) []*plannedRoute {
+	// Only consider locations we have market data for
+	var known []*spacetraders.Location
+	for _, l := range locs {
+		if len(offers[l.Symbol]) > 0 {
+			known = append(known, l)
+		}
+	}
+	sort.Slice(known, func(i, j int) bool { return known[i].Symbol < known[j].Symbol })
+
+	legs := make(map[[2]int]*plannedLeg)
+	for i, from := range known {
+		for j, to := range known {
+			if i == j {
+				continue
+			}
+			leg, err := planLeg(ship, from, to, offers[from.Symbol], offers[to.Symbol])
+			if err != nil {
+				continue
+			}
+			legs[[2]int{i, j}] = leg
+		}
+	}
+
+	var res []*plannedRoute
+	var walk func(path []int)
+	walk = func(path []int) {
+		last := path[len(path)-1]
+		if len(path) > 1 {
+			// Close the loop back to the start
+			if back, ok := legs[[2]int{last, path[0]}]; ok {
+				r := &plannedRoute{}
+				for i := range path {
+					leg := legs[[2]int{path[i], path[(i+1)%len(path)]}]
+					if i == len(path)-1 {
+						leg = back
+					}
+					r.Legs = append(r.Legs, *leg)
+					r.Profit += leg.Profit
+					r.Time += leg.Time
+				}
+				if r.Profit > 0 {
+					res = append(res, r)
+				}
+			}
+		}
+		if len(path) == maxStops {
+			return
+		}
+	next:
+		// Loops always start at their lowest location, so each is only found once
+		for n := path[0] + 1; n < len(known); n++ {
+			for _, p := range path {
+				if p == n {
+					continue next
+				}
+			}
+			if _, ok := legs[[2]int{last, n}]; !ok {
+				continue
+			}
+			walk(append(append([]int{}, path...), n))
+		}
+	}
+	for i := range known {
+		walk([]int{i})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].PerHour() > res[j].PerHour() })
+	return res
+}
+
+// Find a ship to plan with, either one of ours or a type for sale in the system
+func getPlanningShip(c *spacetraders.Client, system, kind string) (*spacetraders.Ship, bool, error) {
+	for _, o := range cache.RestoreObjs(spacetraders.SHIPOBJ) {
+		s := o.(*spacetraders.Ship)
+		if s.ID == kind || s.ShortID == kind {
+			return s, true, nil
+		}
+	}
+
+	ships, err := c.ListShips(system)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't list ships in %q: %v", system, err)
+	}
+	for _, s := range ships {
+		if strings.EqualFold(s.Type, kind) {
+			return &s, false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("unknown ship type or id %q", kind)
+}
+
+func doPlanRoute(c *spacetraders.Client, args []string) error {
+	system := strings.ToUpper(args[0])
+	ship, owned, err := getPlanningShip(c, system, args[1])
+	if err != nil {
+		return err
+	}
+
+	stops := 3
+	if len(args) > 2 {
+		stops, err = strconv.Atoi(args[2])
+		if err != nil || stops < 2 || stops > maxPlanStops {
+			return fmt.Errorf("stops must be a number between 2 and %d, not %q", maxPlanStops, args[2])
+		}
+	}
+
+	locs, err := systemLocations(c, system)
+	if err != nil {
+		return err
+	}
+
+	mh := spacetraders.GetMarketHistory()
+	offers := make(map[string][]spacetraders.Offer)
+	for _, l := range locs {
+		offers[l.Symbol] = mh.Expected(l.Symbol)
+	}
+
+	plans := planRoutes(ship, locs, offers, stops)
+	if len(plans) == 0 {
+		return fmt.Errorf("no profitable routes found for %s in %s, check Market at more locations", ship.Type, system)
+	}
+
+	Out("Best routes for %s in %s:", ship.Type, system)
+	for i, p := range plans {
+		if i == 5 {
+			break
+		}
+		Out("%d: %s", i+1, p.String())
+	}
+
+	if len(args) < 4 {
+		return nil
+	}
+
+	name := args[3]
+	if err := checkNewRouteName(name); err != nil {
+		return err
+	}
+	r := newRoute(name)
+	for _, l := range plans[0].Legs {
+		r.AddStop(l.From.Symbol, simpleCargo(l.Good), tradeRules{})
+	}
+	if err := reportRoute(c, r, nil); err != nil {
+		return err
+	}
+	if err := addRoute(r); err != nil {
+		return err
+	}
+	Out("Created route:\n%s", r.String())
+
+	if owned {
+		return addShipToRoute(c, r, ship)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func TestPlanRoutes(t *testing.T) {
+	ship := &spacetraders.Ship{Type: "JW-MK-I", MaxCargo: 50, Speed: 1}
+	locs := []*spacetraders.Location{
+		{Symbol: "OE-A", Type: "MOON", X: 0, Y: 0},
+		{Symbol: "OE-B", Type: "MOON", X: 10, Y: 0},
+		{Symbol: "OE-C", Type: "MOON", X: 0, Y: 10},
+		{Symbol: "OE-D", Type: "MOON", X: 100, Y: 100},
+	}
+	fuel := spacetraders.Offer{Symbol: "FUEL", VolumePerUnit: 1, PurchasePricePerUnit: 2, SellPricePerUnit: 1, QuantityAvailable: 1000}
+	offer := func(good string, buy, sell int) spacetraders.Offer {
+		return spacetraders.Offer{Symbol: good, VolumePerUnit: 1, PurchasePricePerUnit: buy, SellPricePerUnit: sell, QuantityAvailable: 1000}
+	}
+	offers := map[string][]spacetraders.Offer{
+		"OE-A": {fuel, offer("METALS", 5, 4), offer("FOOD", 20, 18)},
+		"OE-B": {fuel, offer("METALS", 10, 9), offer("FOOD", 10, 8)},
+		"OE-C": {fuel, offer("METALS", 8, 7)},
+	}
+
+	plans := planRoutes(ship, locs, offers, 3)
+	if len(plans) == 0 {
+		t.Fatalf("no plans found")
+	}
+
+	best := plans[0]
+	if len(best.Legs) != 2 {
+		t.Fatalf("want a 2 stop loop, got %s", best)
+	}
+	if best.Legs[0].From.Symbol != "OE-A" || best.Legs[0].Good != "METALS" {
+		t.Errorf("want METALS from OE-A, got %s", best)
+	}
+	if best.Legs[1].From.Symbol != "OE-B" || best.Legs[1].Good != "FOOD" {
+		t.Errorf("want FOOD from OE-B, got %s", best)
+	}
+
+	for i := 1; i < len(plans); i++ {
+		if plans[i].PerHour() > plans[i-1].PerHour() {
+			t.Errorf("plans not sorted: %f > %f", plans[i].PerHour(), plans[i-1].PerHour())
+		}
+	}
+
+	for _, p := range plans {
+		for _, l := range p.Legs {
+			if l.From.Symbol == "OE-D" || l.To.Symbol == "OE-D" {
+				t.Errorf("planned a route through a location without market data: %s", p)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section:    "Automation",
+			Name:       "RemoveShipFromRoute",
+			Usage:      "RemoveShipFromRoute <route name> <ship id>",
+			Validators: []string{"", "ship"},
+			Help:       "Stop automating a ship on a route. The ship stays wherever it is.",
+			Do:         doRemoveShipFromRoute,
+			MinArgs:    2,
+			MaxArgs:    2,
+		},
+		{
+			Section: "Automation",
+			Name:    "DeleteRoute",
+			Usage:   "DeleteRoute <route name>",
+			Help:    "Delete a trade route. Any ships on it stop being automated.",
+			Do:      doDeleteRoute,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Automation",
+			Name:    "RenameRoute",
+			Usage:   "RenameRoute <route name> <new name>",
+			Help:    "Rename a trade route.",
+			Do:      doRenameRoute,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+		{
+			Section: "Automation",
+			Name:    "CloneRoute",
+			Usage:   "CloneRoute <route name> <new name>",
+			Help: "Create a new trade route with the same stops as an existing one, but no ships. " +
+				"Like CreateRoute, use -force to clone a route that can't work.",
+			Do:      doCloneRoute,
+			MinArgs: 2,
+			MaxArgs: 2,
+		},
+		{
+			Section: "Automation",
+			Name:    "PauseRoute",
+			Usage:   "PauseRoute <route name>",
+			Help:    "Stop processing ships on a route until it's resumed.",
+			Do:      doPauseRoute,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Automation",
+			Name:    "ResumeRoute",
+			Usage:   "ResumeRoute <route name>",
+			Help:    "Resume processing ships on a paused route.",
+			Do:      doResumeRoute,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Automation",
+			Name:    "EditRoute",
+			Usage:   "EditRoute <route name> <insert|replace|remove> <stop #> [location cargo]",
+			Help: "Change the stops of a trade route. insert adds a new stop before the given " +
+				"stop number (or at the end if it's one past the last), replace changes the " +
+				"location and cargo of a stop, and remove deletes it. Ships keep heading to " +
+				"the same location where possible. Edits that leave a route that can't " +
+				"work are refused unless -force is given.",
+			Do:      doEditRoute,
+			MinArgs: 3,
+			MaxArgs: 5,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+func doRemoveShipFromRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	ship, err := getShip(c, args[1])
+	if err != nil {
+		return fmt.Errorf("can't find ship %q: %v", args[1], err)
+	}
+
+	if err := r.DelShip(ship.ID); err != nil {
+		return fmt.Errorf("can't remove %s from %s: %v", ship.ShortID, r.Name, err)
+	}
+	r.Log("%s: Removed from route", ship.ShortID)
+
+	return nil
+}
+
+func doDeleteRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	deleteRoute(r.Name)
+	Out("Deleted route %s", r.Name)
+	if ids := r.shipIDs(); len(ids) > 0 {
+		ships := []string{}
+		for _, s := range ids {
+			ships = append(ships, shortShip(s))
+		}
+		Out("Ships no longer automated: %s", strings.Join(ships, ", "))
+	}
+
+	return nil
+}
+
+// Make sure a route name isn't taken
+func checkNewRouteName(name string) error {
+	routesMu.RLock()
+	r, ok := routes[strings.ToLower(name)]
+	routesMu.RUnlock()
+	if ok {
+		return fmt.Errorf("a route already exists named %q: %s", name, r.Short())
+	}
+	return nil
+}
+
+func doRenameRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+	if err := checkNewRouteName(args[1]); err != nil {
+		return err
+	}
+
+	old := r.Name
+	if err := renameRoute(r, args[1]); err != nil {
+		return err
+	}
+	r.Log("Renamed from %s", old)
+
+	return nil
+}
+
+func doCloneRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+	if err := checkNewRouteName(args[1]); err != nil {
+		return err
+	}
+
+	clone := r.copyStops(args[1])
+	if err := reportRoute(c, clone, nil); err != nil {
+		return err
+	}
+	if err := addRoute(clone); err != nil {
+		return err
+	}
+	Out("Created route:\n%s", clone.String())
+
+	return nil
+}
+
+// A new route with the same stops and settings, but no ships
+func (r *route) copyStops(name string) *route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := newRoute(name)
+	res.AutoFuel = r.AutoFuel
+	res.Budget = r.Budget
+	for i := range r.Destinations {
+		res.AddStop(r.Destinations[i], r.Cargos[i], r.Rules[i])
+	}
+	return res
+}
+
+func doPauseRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+	if err := r.SetPaused(true); err != nil {
+		return err
+	}
+	r.Log("Paused")
+
+	return nil
+}
+
+func doResumeRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+	if err := r.SetPaused(false); err != nil {
+		return err
+	}
+	r.Log("Resumed")
+
+	return nil
+}
+
+// Pause or resume processing the ships on the route
+func (r *route) SetPaused(paused bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Paused == paused {
+		if paused {
+			return fmt.Errorf("route %s is already paused", r.Name)
+		}
+		return fmt.Errorf("route %s isn't paused", r.Name)
+	}
+	r.Paused = paused
+
+	return nil
+}
+
+func doEditRoute(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	op := strings.ToLower(args[1])
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid stop number %q: %v", args[2], err)
+	}
+	// Stops are numbered from 1
+	idx := stop - 1
+
+	var loc string
+	var cargo stopCargo
+	switch op {
+	case "insert", "replace":
+		if len(args) != 5 {
+			return fmt.Errorf("%s needs a location and cargo", op)
+		}
+		loc, cargo, err = parseStop(c, args[3], args[4])
+		if err != nil {
+			return fmt.Errorf("invalid stop %v: %v", args[3:5], err)
+		}
+	case "remove":
+		if len(args) != 3 {
+			return fmt.Errorf("remove only takes a stop number")
+		}
+	default:
+		return fmt.Errorf("unknown edit %q, must be one of insert, replace, remove", args[1])
+	}
+
+	edit := func(r *route) error {
+		switch op {
+		case "insert":
+			return r.InsertStop(idx, loc, cargo)
+		case "replace":
+			return r.ReplaceStop(idx, loc, cargo)
+		default:
+			return r.RemoveStop(idx)
+		}
+	}
+
+	// Check the edited stops before changing the route the ships are on
+	edited := r.copyStops(r.Name)
+	if err := edit(edited); err != nil {
+		return err
+	}
+	if err := reportRoute(c, edited, nil); err != nil {
+		return err
+	}
+	if err := edit(r); err != nil {
+		return err
+	}
+	r.Log("Edited: %s", strings.Join(args[1:], " "))
+	Out(r.String())
+
+	return nil
+}
+
+// Add a new stop before stop i, or at the end if i is the number of stops.
+func (r *route) InsertStop(i int, loc string, cargo stopCargo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i > len(r.Destinations) {
+		return fmt.Errorf("stop must be between 1 and %d", len(r.Destinations)+1)
+	}
+
+	r.Destinations = append(r.Destinations[:i], append([]string{loc}, r.Destinations[i:]...)...)
+	r.Cargos = append(r.Cargos[:i], append([]stopCargo{cargo}, r.Cargos[i:]...)...)
+	r.Rules = append(r.Rules[:i], append([]tradeRules{{}}, r.Rules[i:]...)...)
+	for s, next := range r.Ships {
+		if next >= i {
+			r.Ships[s] = next + 1
+		}
+	}
+
+	return nil
+}
+
+func (r *route) ReplaceStop(i int, loc string, cargo stopCargo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.Destinations) {
+		return fmt.Errorf("stop must be between 1 and %d", len(r.Destinations))
+	}
+
+	r.Destinations[i] = loc
+	r.Cargos[i] = cargo
+
+	return nil
+}
+
+// Remove a stop. Ships heading there will go to the following stop instead.
+func (r *route) RemoveStop(i int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.Destinations) {
+		return fmt.Errorf("stop must be between 1 and %d", len(r.Destinations))
+	}
+	if len(r.Destinations) == 1 {
+		return fmt.Errorf("can't remove the last stop of %s, delete the route instead", r.Name)
+	}
+
+	r.Destinations = append(r.Destinations[:i], r.Destinations[i+1:]...)
+	r.Cargos = append(r.Cargos[:i], r.Cargos[i+1:]...)
+	r.Rules = append(r.Rules[:i], r.Rules[i+1:]...)
+	for s, next := range r.Ships {
+		if next > i {
+			r.Ships[s] = next - 1
+		}
+		r.Ships[s] %= len(r.Destinations)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEditStops(t *testing.T) {
+	tests := []struct {
+		desc      string
+		edit      func(r *route) error
+		wantDests []string
+		wantShips map[string]int
+		wantErr   bool
+	}{
+		{
+			desc:      "insert before a ship's stop",
+			edit:      func(r *route) error { return r.InsertStop(1, "OE-X", simpleCargo("FUEL")) },
+			wantDests: []string{"OE-A", "OE-X", "OE-B", "OE-C"},
+			wantShips: map[string]int{"s1": 0, "s2": 2, "s3": 3},
+		},
+		{
+			desc:      "insert at the end",
+			edit:      func(r *route) error { return r.InsertStop(3, "OE-X", simpleCargo("FUEL")) },
+			wantDests: []string{"OE-A", "OE-B", "OE-C", "OE-X"},
+			wantShips: map[string]int{"s1": 0, "s2": 1, "s3": 2},
+		},
+		{
+			desc:    "insert out of range",
+			edit:    func(r *route) error { return r.InsertStop(4, "OE-X", simpleCargo("FUEL")) },
+			wantErr: true,
+		},
+		{
+			desc:      "remove a ship's stop",
+			edit:      func(r *route) error { return r.RemoveStop(1) },
+			wantDests: []string{"OE-A", "OE-C"},
+			wantShips: map[string]int{"s1": 0, "s2": 1, "s3": 1},
+		},
+		{
+			desc:      "remove the last stop",
+			edit:      func(r *route) error { return r.RemoveStop(2) },
+			wantDests: []string{"OE-A", "OE-B"},
+			wantShips: map[string]int{"s1": 0, "s2": 1, "s3": 0},
+		},
+		{
+			desc:      "replace",
+			edit:      func(r *route) error { return r.ReplaceStop(0, "OE-X", simpleCargo("FUEL")) },
+			wantDests: []string{"OE-X", "OE-B", "OE-C"},
+			wantShips: map[string]int{"s1": 0, "s2": 1, "s3": 2},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRoute("test")
+			r.Destinations = []string{"OE-A", "OE-B", "OE-C"}
+			r.Cargos = []stopCargo{simpleCargo("METALS"), simpleCargo("NONE"), simpleCargo("DRONES")}
+			r.Rules = []tradeRules{{}, {}, {}}
+			r.Ships = map[string]int{"s1": 0, "s2": 1, "s3": 2}
+
+			err := tc.edit(r)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("wanted error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantDests, r.Destinations); diff != "" {
+				t.Errorf("bad destinations: -want +got\n%s", diff)
+			}
+			if len(r.Cargos) != len(r.Destinations) || len(r.Rules) != len(r.Destinations) {
+				t.Errorf("stops don't match: %v, %v, %v", r.Destinations, r.Cargos, r.Rules)
+			}
+			if diff := cmp.Diff(tc.wantShips, r.Ships); diff != "" {
+				t.Errorf("bad ships: -want +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEditChecksRoute(t *testing.T) {
+	defer SetTUI(ui)
+	SetTUI(&recordUI{})
+	defer func() { forced = false }()
+	r := newRoute("edit-check")
+	for _, s := range [][2]string{{"CHK-A", "METALS/NONE"}, {"CHK-B", "NONE/METALS"}, {"CHK-C", "NONE/NONE"}} {
+		cargo, err := parseStopCargo(s[1])
+		if err != nil {
+			t.Fatalf("bad cargo %q: %v", s[1], err)
+		}
+		r.AddStop(s[0], cargo, tradeRules{})
+	}
+	if err := addRoute(r); err != nil {
+		t.Fatalf("can't add route: %v", err)
+	}
+	defer deleteRoute(r.Name)
+
+	// Without CHK-B, the METALS bought at CHK-A are never sold
+	forced = false
+	if err := doEditRoute(nil, []string{r.Name, "remove", "2"}); err == nil {
+		t.Errorf("removed the only stop selling METALS")
+	}
+	if diff := cmp.Diff([]string{"CHK-A", "CHK-B", "CHK-C"}, r.Destinations); diff != "" {
+		t.Errorf("refused edit changed the route: -want +got\n%s", diff)
+	}
+
+	forced = true
+	if err := doEditRoute(nil, []string{r.Name, "remove", "2"}); err != nil {
+		t.Fatalf("forced edit failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"CHK-A", "CHK-C"}, r.Destinations); diff != "" {
+		t.Errorf("bad forced edit: -want +got\n%s", diff)
+	}
+
+	forced = false
+	if err := doCloneRoute(nil, []string{r.Name, "edit-check-clone"}); err == nil {
+		t.Errorf("cloned a route that can't work")
+	}
+	if _, err := getRoute("edit-check-clone"); err == nil {
+		t.Errorf("refused clone was created")
+	}
+	forced = true
+	if err := doCloneRoute(nil, []string{r.Name, "edit-check-clone"}); err != nil {
+		t.Errorf("forced clone failed: %v", err)
+	}
+	deleteRoute("edit-check-clone")
+}
+
+func TestSetPaused(t *testing.T) {
+	r := newRoute("pause")
+	if err := r.SetPaused(false); err == nil {
+		t.Errorf("resumed a route that isn't paused")
+	}
+	if err := r.SetPaused(true); err != nil || !r.Paused {
+		t.Errorf("can't pause: %v", err)
+	}
+	if err := r.SetPaused(true); err == nil {
+		t.Errorf("paused a route twice")
+	}
+	if err := r.SetPaused(false); err != nil || r.Paused {
+		t.Errorf("can't resume: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Automation",
+			Name:    "SetRouteRules",
+			Usage:   "SetRouteRules <route name> <stop #> [clear] [maxbuy=N] [minsell=N] [margin=N] [hold=true|false] [minqty=N]",
+			Help: "Set price guards for a stop on a route. maxbuy: don't buy cargo above this price. " +
+				"minsell: don't sell below this price. margin: don't sell unless the price is at " +
+				"least this percent above what was paid. hold: keep cargo rather than sell at a " +
+				"loss. minqty: don't buy if fewer units than this can be bought. With no rules, " +
+				"show the current ones.",
+			Do:      doSetRouteRules,
+			MinArgs: 2,
+			MaxArgs: 7,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+// Limits on what automation will trade at a stop. Zero values mean no limit.
+type tradeRules struct {
+	MaxBuy    int
+	MinSell   int
+	MinMargin int
+	Hold      bool
+	MinQty    int
+}
+
+func (t tradeRules) String() string {
+	res := []string{}
+	if t.MaxBuy > 0 {
+		res = append(res, fmt.Sprintf("maxbuy=%d", t.MaxBuy))
+	}
+	if t.MinSell > 0 {
+		res = append(res, fmt.Sprintf("minsell=%d", t.MinSell))
+	}
+	if t.MinMargin > 0 {
+		res = append(res, fmt.Sprintf("margin=%d", t.MinMargin))
+	}
+	if t.Hold {
+		res = append(res, "hold=true")
+	}
+	if t.MinQty > 0 {
+		res = append(res, fmt.Sprintf("minqty=%d", t.MinQty))
+	}
+	return strings.Join(res, " ")
+}
+
+// Update the rules from a list of key=value settings
+func (t *tradeRules) Parse(settings []string) error {
+	for _, s := range settings {
+		if strings.ToLower(s) == "clear" {
+			*t = tradeRules{}
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("rules must look like key=value, not %q", s)
+		}
+		key := strings.ToLower(kv[0])
+		if key == "hold" {
+			hold, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid value for hold %q: %v", kv[1], err)
+			}
+			t.Hold = hold
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSuffix(kv[1], "%"))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value for %s: %q", key, kv[1])
+		}
+		switch key {
+		case "maxbuy":
+			t.MaxBuy = n
+		case "minsell":
+			t.MinSell = n
+		case "margin":
+			t.MinMargin = n
+		case "minqty":
+			t.MinQty = n
+		default:
+			return fmt.Errorf("unknown rule %q", key)
+		}
+	}
+
+	return nil
+}
+
+// Check if selling at price is allowed, given what was paid for the goods
+// (if known). Returns the reason if not.
+func (t tradeRules) CanSell(price, paid int, known bool) (bool, string) {
+	if t.MinSell > 0 && price < t.MinSell {
+		return false, fmt.Sprintf("price %d is below minimum %d", price, t.MinSell)
+	}
+	if !known {
+		return true, ""
+	}
+	if t.MinMargin > 0 && price*100 < paid*(100+t.MinMargin) {
+		return false, fmt.Sprintf("price %d is less than %d%% over the %d paid", price, t.MinMargin, paid)
+	}
+	if t.Hold && price < paid {
+		return false, fmt.Sprintf("price %d is less than the %d paid", price, paid)
+	}
+	return true, ""
+}
+
+// Check if buying qty at price is allowed. Returns the reason if not.
+func (t tradeRules) CanBuy(price, qty int) (bool, string) {
+	if t.MaxBuy > 0 && price > t.MaxBuy {
+		return false, fmt.Sprintf("price %d is above maximum %d", price, t.MaxBuy)
+	}
+	if qty < t.MinQty {
+		return false, fmt.Sprintf("only %d available, minimum is %d", qty, t.MinQty)
+	}
+	return true, ""
+}
+
+// What a ship last paid per unit for a good on this route
+func (r *route) lastPurchase(ship, good string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.Trades) - 1; i >= 0; i-- {
+		t := r.Trades[i]
+		if t.Ship == ship && t.Good == good && t.Buy {
+			return t.Price, true
+		}
+	}
+	return 0, false
+}
+
+func doSetRouteRules(c *spacetraders.Client, args []string) error {
+	r, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+
+	stop, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid stop number %q", args[1])
+	}
+	loc, rules, err := r.SetRules(stop-1, args[2:])
+	if err != nil {
+		return err
+	}
+	if len(args) > 2 {
+		r.Log("Rules for %s set to: %s", loc, rules)
+	}
+	Out("Rules for stop %d (%s): %s", stop, loc, rules)
+
+	return nil
+}
+
+// Change the rules of stop i, returning its location and the new rules
+func (r *route) SetRules(i int, args []string) (string, tradeRules, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i < 0 || i >= len(r.Destinations) {
+		return "", tradeRules{}, fmt.Errorf("stop must be between 1 and %d, not %d", len(r.Destinations), i+1)
+	}
+
+	rules := r.Rules[i]
+	if err := rules.Parse(args); err != nil {
+		return "", tradeRules{}, err
+	}
+	r.Rules[i] = rules
+
+	return r.Destinations[i], rules, nil
+}
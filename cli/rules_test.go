@@ -0,0 +1,71 @@
+package cli
+
+import "testing"
+
+func TestTradeRules(t *testing.T) {
+	var rules tradeRules
+	if err := rules.Parse([]string{"maxbuy=10", "minsell=5", "margin=20%", "hold=true", "minqty=3"}); err != nil {
+		t.Fatalf("can't parse rules: %v", err)
+	}
+	want := tradeRules{MaxBuy: 10, MinSell: 5, MinMargin: 20, Hold: true, MinQty: 3}
+	if rules != want {
+		t.Errorf("want %+v, got %+v", want, rules)
+	}
+	if err := rules.Parse([]string{"bogus=1"}); err == nil {
+		t.Errorf("no error for unknown rule")
+	}
+
+	sells := []struct {
+		desc        string
+		price, paid int
+		known       bool
+		want        bool
+	}{
+		{"below minimum", 4, 0, false, false},
+		{"unknown purchase", 6, 0, false, true},
+		{"under margin", 11, 10, true, false},
+		{"over margin", 12, 10, true, true},
+	}
+	for _, tc := range sells {
+		if got, why := rules.CanSell(tc.price, tc.paid, tc.known); got != tc.want {
+			t.Errorf("%s: want %v, got %v (%s)", tc.desc, tc.want, got, why)
+		}
+	}
+
+	if ok, _ := (tradeRules{Hold: true}).CanSell(9, 10, true); ok {
+		t.Errorf("hold: sold at a loss")
+	}
+	if ok, _ := rules.CanBuy(11, 10); ok {
+		t.Errorf("bought above maximum")
+	}
+	if ok, _ := rules.CanBuy(10, 2); ok {
+		t.Errorf("bought below minimum quantity")
+	}
+	if ok, why := rules.CanBuy(10, 3); !ok {
+		t.Errorf("didn't buy: %s", why)
+	}
+
+	if err := rules.Parse([]string{"clear"}); err != nil || rules != (tradeRules{}) {
+		t.Errorf("clear didn't reset rules: %+v, %v", rules, err)
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	r := newRoute("rules")
+	r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+	r.AddStop("OE-B", simpleCargo("NONE"), tradeRules{MinMargin: 20})
+
+	loc, rules, err := r.SetRules(1, []string{"maxbuy=10"})
+	if err != nil {
+		t.Fatalf("can't set rules: %v", err)
+	}
+	want := tradeRules{MinMargin: 20, MaxBuy: 10}
+	if loc != "OE-B" || rules != want || r.Rules[1] != want {
+		t.Errorf("bad rules for %s: %+v, route has %+v", loc, rules, r.Rules[1])
+	}
+	for _, i := range []int{-1, 2} {
+		if _, _, err := r.SetRules(i, []string{"maxbuy=10"}); err == nil {
+			t.Errorf("set rules for stop %d of 2", i+1)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+	"github.com/zigdon/spacetraders/tasks"
+)
+
+// Task kinds for scheduled command lines
+const (
+	commandKind = "command"
+	arrivalKind = "arrival"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Tasks",
+			Name:    "At",
+			Usage:   "At <now|duration|HH:MM> <command...>",
+			Help: "Run a command later, after a duration (e.g. 5m) or at a time of day. " +
+				"Its output goes to the messages window, and typed commands wait until it's done.",
+			Do:      doAt,
+			MinArgs: 2,
+			MaxArgs: -1,
+		},
+		{
+			Section: "Tasks",
+			Name:    "Every",
+			Usage:   "Every <duration> <command...>",
+			Help: "Run a command repeatedly, starting after the duration. Its output goes " +
+				"to the messages window. Use Schedule to change how it repeats.",
+			Do:      doEvery,
+			MinArgs: 2,
+			MaxArgs: -1,
+		},
+		{
+			Section: "Tasks",
+			Name:    "OnArrival",
+			Usage:   "OnArrival <flightID|ship> <command...>",
+			Help: "Run a command once a flight arrives, e.g. OnArrival s-1 sell s-1 METALS 25. " +
+				"Its output goes to the messages window.",
+			Do:      doOnArrival,
+			MinArgs: 2,
+			MaxArgs: -1,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+
+	if err := tasks.RegisterKind(commandKind, commandTask); err != nil {
+		log.Fatalf("Can't register command tasks: %v", err)
+	}
+	if err := tasks.RegisterKind(arrivalKind, arrivalTask); err != nil {
+		log.Fatalf("Can't register arrival tasks: %v", err)
+	}
+}
+
+// The ID for a new scheduled command
+func nextCommandID() string {
+	n := 0
+	for _, t := range tasks.GetTaskQueue().List() {
+		var i int
+		if _, err := fmt.Sscanf(t.Key, "cmd-%d", &i); err == nil && i > n {
+			n = i
+		}
+	}
+	return fmt.Sprintf("cmd-%d", n+1)
+}
+
+// Make sure a line to be run later starts with a command that can be
+// scheduled. The arguments are checked when it runs.
+func checkCommand(words []string) (string, error) {
+	matches := filter(allCommands, words[0], filterPrefix)
+	switch {
+	case len(matches) == 0:
+		return "", fmt.Errorf("unknown command %q", words[0])
+	case len(matches) > 1:
+		return "", fmt.Errorf("%q could mean %v", words[0], matches)
+	}
+	name := strings.ToLower(matches[0])
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if cmd, ok := commands[name]; ok && cmd.NoSchedule {
+		return "", fmt.Errorf("%s can't be scheduled", cmd.Name)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Run a scheduled line, and show its output in the messages window
+func runScheduled(c *spacetraders.Client, id, line string) error {
+	out, err := runCaptured(c, line)
+	if out != "" {
+		ui.Msg("%s: %s", id, out)
+	}
+	if err != nil {
+		ui.Msg("%s: %q failed: %v", id, line, err)
+		return fmt.Errorf("%s: %v", id, err)
+	}
+	return nil
+}
+
+func commandTask(args []string) (func(*spacetraders.Client) error, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("need an ID and a command, got %q", args)
+	}
+	id, line := args[0], args[1]
+	return func(c *spacetraders.Client) error {
+		return runScheduled(c, id, line)
+	}, nil
+}
+
+// Run a line once a ship is no longer on a flight, checking again in a bit if
+// the flight is late.
+func arrivalTask(args []string) (func(*spacetraders.Client) error, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("need an ID, ship, flight and command, got %q", args)
+	}
+	id, shipID, flightID, line := args[0], args[1], args[2], args[3]
+	return func(c *spacetraders.Client) error {
+		ship, err := getShip(c, shipID)
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		if ship.FlightPlanID == flightID {
+			return tasks.GetTaskQueue().AddKind(id, "", time.Now().Add(arrivalRecheck), tasks.Schedule{}, arrivalKind, args...)
+		}
+		return runScheduled(c, id, line)
+	}, nil
+}
+
+func doAt(c *spacetraders.Client, args []string) error {
+	when, err := parseWhen(args[0], time.Now())
+	if err != nil {
+		return err
+	}
+	line, err := checkCommand(args[1:])
+	if err != nil {
+		return err
+	}
+
+	id := nextCommandID()
+	if err := tasks.GetTaskQueue().AddKind(id, "", when, tasks.Schedule{}, commandKind, id, line); err != nil {
+		return err
+	}
+	Out("%s: will run %q at %s", id, line, when.Format("15:04:05"))
+
+	return nil
+}
+
+func doEvery(c *spacetraders.Client, args []string) error {
+	every, err := time.ParseDuration(args[0])
+	if err != nil || every < time.Second {
+		return fmt.Errorf("invalid repeat %q, must be at least 1s", args[0])
+	}
+	line, err := checkCommand(args[1:])
+	if err != nil {
+		return err
+	}
+
+	id := nextCommandID()
+	sched := tasks.Schedule{Every: every}
+	if err := tasks.GetTaskQueue().AddKind(id, "", time.Now().Add(every), sched, commandKind, id, line); err != nil {
+		return err
+	}
+	Out("%s: will run %q every %s", id, line, every)
+
+	return nil
+}
+
+func doOnArrival(c *spacetraders.Client, args []string) error {
+	line, err := checkCommand(args[1:])
+	if err != nil {
+		return err
+	}
+
+	flightID := args[0]
+	if ship, err := getShip(c, args[0]); err == nil {
+		if ship.FlightPlanID == "" {
+			return fmt.Errorf("%s isn't in flight", ship.ShortID)
+		}
+		flightID = ship.FlightPlanID
+	}
+	fp, err := c.ShowFlight(flightID)
+	if err != nil {
+		return fmt.Errorf("can't find a ship or flight plan %q: %v", args[0], err)
+	}
+
+	id := nextCommandID()
+	if err := tasks.GetTaskQueue().AddKind(id, "", fp.ArrivesAt, tasks.Schedule{}, arrivalKind, id, fp.ShipID, fp.ID, line); err != nil {
+		return err
+	}
+	Out("%s: will run %q when %s arrives at %s, in %s", id, line, fp.ShortShipID, fp.Destination,
+		time.Until(fp.ArrivesAt).Truncate(time.Second))
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type recordUI struct {
+	msgs []string
+}
+
+func (r *recordUI) PrintMsg(buf, prefix, format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func (r *recordUI) Msg(format string, args ...interface{}) {
+	r.PrintMsg("msgs", "-", format, args...)
+}
+
+func (r *recordUI) Toggle(string) error { return nil }
+
+func TestCommandTask(t *testing.T) {
+	rec := &recordUI{}
+	defer SetTUI(ui)
+	SetTUI(rec)
+	outputBuffer = []string{"typed"}
+	defer func() { outputBuffer = []string{} }()
+
+	f, err := commandTask([]string{"cmd-1", "help claim"})
+	if err != nil {
+		t.Fatalf("can't create task: %v", err)
+	}
+	if err := f(nil); err != nil {
+		t.Errorf("task failed: %v", err)
+	}
+	if len(rec.msgs) != 1 || !strings.HasPrefix(rec.msgs[0], "cmd-1: Claim: ") {
+		t.Errorf("bad messages: %q", rec.msgs)
+	}
+	if len(outputBuffer) != 1 || outputBuffer[0] != "typed" {
+		t.Errorf("output of a typed command was changed: %q", outputBuffer)
+	}
+
+	if _, err := checkCommand([]string{"nosuchcommand", "now"}); err == nil {
+		t.Errorf("accepted an unknown command")
+	}
+	if line, err := checkCommand([]string{"sell", "s-1", "METALS", "25"}); err != nil || line != "sell s-1 METALS 25" {
+		t.Errorf("bad line %q: %v", line, err)
+	}
+	for _, words := range [][]string{{"wait", "all"}, {"exit"}} {
+		if _, err := checkCommand(words); err == nil {
+			t.Errorf("accepted %q, which can't be scheduled", words)
+		}
+	}
+	if _, err := runCaptured(nil, "wait all"); err == nil {
+		t.Errorf("ran a command that can't be scheduled")
+	}
+}
+
+func TestNotifyWaitsForCommand(t *testing.T) {
+	rec := &recordUI{}
+	defer SetTUI(ui)
+	SetTUI(rec)
+
+	// A command is running, capturing its output
+	runMu.Lock()
+	saved := outputBuffer
+	outputBuffer = []string{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Notify([]string{"task finished"})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	Out("captured")
+	captured := outputBuffer
+	outputBuffer = saved
+	runMu.Unlock()
+	<-done
+
+	if diff := cmp.Diff([]string{"captured"}, captured); diff != "" {
+		t.Errorf("bad captured output: -want +got\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"task finished"}, rec.msgs); diff != "" {
+		t.Errorf("bad messages: -want +got\n%s", diff)
+	}
+}
@@ -65,16 +65,6 @@ func init() {
 			MaxArgs:    1,
 			Aliases:    []string{"lsFlights"},
 		},
-		{
-			Section:    "Flight Plans",
-			Name:       "Wait",
-			Usage:      "Wait <flightPlanID>",
-			Validators: []string{"flights"},
-			Help:       "Wait until specified flight arrives",
-			Do:         doWaitForFlight,
-			MinArgs:    1,
-			MaxArgs:    1,
-		},
 	} {
 		if err := Register(c); err != nil {
 			log.Fatalf("Can't register %q: %v", c.Name, err)
@@ -157,20 +147,58 @@ func getShip(c *spacetraders.Client, id string) (*spacetraders.Ship, error) {
 	return nil, fmt.Errorf("can't find ship %q", id)
 }
 
+// How old the cached ships can be before routes fetch them again. They're
+// refreshed by updateShips, and kept current by the client's own orders and
+// flights.
+const shipCacheAge = 2 * time.Minute
+
+// A copy of a ship from the cache, or nil if it's not there or might be out of
+// date. A ship in flight is out of date once its flight is due.
+func freshShip(id string, now time.Time) *spacetraders.Ship {
+	if now.Sub(cache.ObjsUpdated(spacetraders.SHIPOBJ)) > shipCacheAge {
+		return nil
+	}
+	for _, o := range cache.RestoreObjs(spacetraders.SHIPOBJ) {
+		s := *o.(*spacetraders.Ship)
+		if s.ID != id && s.ShortID != id {
+			continue
+		}
+		if s.FlightPlanID != "" {
+			fp, ok := spacetraders.GetArrivalWatcher().Flight(s.FlightPlanID)
+			if !ok || !fp.ArrivesAt.After(now) {
+				return nil
+			}
+		}
+		return &s
+	}
+	return nil
+}
+
+// Get a ship from the cache if it's fresh, or from the API
+func cachedShip(c *spacetraders.Client, id string) (*spacetraders.Ship, error) {
+	if s := freshShip(id, time.Now()); s != nil {
+		return s, nil
+	}
+	return getShip(c, id)
+}
+
+// The short ID of a ship, if we know it
+func shortShip(id string) string {
+	for _, o := range cache.RestoreObjs(spacetraders.SHIPOBJ) {
+		if s := o.(*spacetraders.Ship); s.ID == id {
+			return s.ShortID
+		}
+	}
+	return id
+}
+
 func doCreateFlight(c *spacetraders.Client, args []string) error {
 	flight, err := c.CreateFlight(args[0], args[1])
 	if err != nil {
 		return fmt.Errorf("error creating flight plan to %q: %v", args[1], err)
 	}
 
-	tasks.Run("updateShips")
 	Out("Created flight plan: %s", flight.Short())
-	tasks.GetTaskQueue().Add(
-		flight.ShortID,
-		fmt.Sprintf("%s: %s arrived at %s", flight.ShortID, flight.ShortShipID, flight.Destination),
-		flight.ArrivesAt,
-		0, nil)
-	tasks.RunAt("updateShips", flight.ArrivesAt)
 
 	return nil
 }
@@ -186,26 +214,3 @@ func doShowFlight(c *spacetraders.Client, args []string) error {
 
 	return nil
 }
-
-func doWaitForFlight(c *spacetraders.Client, args []string) error {
-	flight, err := c.ShowFlight(args[0])
-	if err != nil {
-		return fmt.Errorf("error listing flight plan %q: %v", args[0], err)
-	}
-
-	if flight.ArrivesAt.Before(time.Now()) {
-		return fmt.Errorf("flight %s (%s) already arrived", flight.ShortID, flight.ID)
-	}
-
-	// TODO: Allow interrupting the wait
-	delay := flight.ArrivesAt.Sub(time.Now()).Truncate(time.Second)
-	Out("Waiting %s for %s (%s) to arrive...", delay, flight.ShortID, flight.ID)
-	select {
-	case <-time.After(delay):
-	case <-time.After(time.Minute):
-		Out("... still waiting for %s", flight.ShortID)
-	}
-	Out("... %s arrived!", flight.ShortID)
-
-	return nil
-}
@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Automation",
+			Name:    "SimulateRoute",
+			Usage:   "SimulateRoute <route name> [hours] [ship type|ship id]",
+			Help: "Replay a route against the market prices recorded over the last [hours] " +
+				"(default 24), without making any trades or flights. Where there's no " +
+				"recording for the time a stop is reached, average prices are used " +
+				"instead. Uses the first ship on the route unless one of our ships or " +
+				"their type is given. Only known locations and ships are used, without " +
+				"calling the API. Reports the expected profit, fuel use and problems for " +
+				"each leg.",
+			Do:      doSimulateRoute,
+			MinArgs: 1,
+			MaxArgs: 3,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+// Provides the offers at a location at a point in time, and whether they were
+// recorded or estimated.
+type simMarket func(loc string, at time.Time) ([]spacetraders.Offer, bool)
+
+// Use the snapshot recorded most recently before the time, or the average
+// prices if there isn't one.
+func recordedMarket(mh *spacetraders.MarketHistory) simMarket {
+	return func(loc string, at time.Time) ([]spacetraders.Offer, bool) {
+		h := mh.History(loc)
+		for i := len(h) - 1; i >= 0; i-- {
+			if !h[i].Time.After(at) {
+				return h[i].Offers, true
+			}
+		}
+		return mh.Expected(loc), false
+	}
+}
+
+// Results for one leg of the route, from a stop to the next
+type simLeg struct {
+	From, To  string
+	Runs      int
+	Bought    map[string]int
+	Sold      map[string]int
+	Cost      int
+	Revenue   int
+	Fuel      int
+	FuelCost  int
+	Estimated int
+	Problems  map[string]int
+}
+
+func (l *simLeg) Profit() int {
+	return l.Revenue - l.Cost - l.FuelCost
+}
+
+func (l *simLeg) problem(format string, args ...interface{}) {
+	l.Problems[fmt.Sprintf(format, args...)]++
+}
+
+type simResult struct {
+	Start, End time.Time
+	Legs       []*simLeg
+	Loops      int
+	Stuck      string
+}
+
+func (s *simResult) Profit() int {
+	total := 0
+	for _, l := range s.Legs {
+		total += l.Profit()
+	}
+	return total
+}
+
+func (s *simResult) String() string {
+	elapsed := s.End.Sub(s.Start)
+	fuel := 0
+	for _, l := range s.Legs {
+		fuel += l.Fuel
+	}
+	perHour := 0.0
+	if elapsed > 0 {
+		perHour = float64(s.Profit()) / elapsed.Hours()
+	}
+	res := []string{fmt.Sprintf("Profit: %d over %s (%.0f/hour), %d loops, %d fuel used",
+		s.Profit(), elapsed.Truncate(time.Second), perHour, s.Loops, fuel)}
+	if s.Stuck != "" {
+		res = append(res, fmt.Sprintf("Stopped early: %s", s.Stuck))
+	}
+
+	list := func(m map[string]int) string {
+		if len(m) == 0 {
+			return "nothing"
+		}
+		var goods []string
+		for g, n := range m {
+			goods = append(goods, fmt.Sprintf("%d %s", n, g))
+		}
+		sort.Strings(goods)
+		return strings.Join(goods, ", ")
+	}
+	for i, l := range s.Legs {
+		res = append(res, fmt.Sprintf("  %d. %s -> %s: %d runs, profit: %d, fuel: %d (%d credits)",
+			i+1, l.From, l.To, l.Runs, l.Profit(), l.Fuel, l.FuelCost))
+		res = append(res, fmt.Sprintf("     bought %s for %d, sold %s for %d",
+			list(l.Bought), l.Cost, list(l.Sold), l.Revenue))
+		if l.Estimated > 0 {
+			res = append(res, fmt.Sprintf("     %d visits used average prices", l.Estimated))
+		}
+		var problems []string
+		for p, n := range l.Problems {
+			problems = append(problems, fmt.Sprintf("     %dx %s", n, p))
+		}
+		sort.Strings(problems)
+		res = append(res, problems...)
+	}
+
+	return strings.Join(res, "\n")
+}
+
+// Run the route's trading logic for the duration, starting with an empty
+// ship at the first stop.
+func simulateRoute(r *route, ship *spacetraders.Ship, locs map[string]*spacetraders.Location, market simMarket, start time.Time, d time.Duration) *simResult {
+	res := &simResult{Start: start, End: start}
+	n := len(r.Destinations)
+	for i := range r.Destinations {
+		res.Legs = append(res.Legs, &simLeg{
+			From:     r.Destinations[i],
+			To:       r.Destinations[(i+1)%n],
+			Bought:   make(map[string]int),
+			Sold:     make(map[string]int),
+			Problems: make(map[string]int),
+		})
+	}
+
+	cargo := make(map[string]int)
+	paid := make(map[string]int)
+	volume := map[string]int{"FUEL": 1}
+	space := func() int {
+		used := 0
+		for g, q := range cargo {
+			used += q * volume[g]
+		}
+		return ship.MaxCargo - used
+	}
+
+	now := start
+	end := start.Add(d)
+	for stop := 0; now.Before(end); stop = (stop + 1) % n {
+		leg := res.Legs[stop]
+		prev := res.Legs[(stop+n-1)%n]
+		offers, recorded := market(r.Destinations[stop], now)
+		if len(offers) == 0 {
+			leg.problem("no market data")
+			res.Stuck = fmt.Sprintf("no market data for %s", r.Destinations[stop])
+			break
+		}
+		if !recorded {
+			leg.Estimated++
+		}
+		find := func(good string) *spacetraders.Offer {
+			for i, o := range offers {
+				if o.Symbol == good {
+					return &offers[i]
+				}
+			}
+			return nil
+		}
+
+		// Sell what was carried on the previous leg
+		for good, qty := range cargo {
+			if good == "FUEL" || qty == 0 || !r.Cargos[stop].Sells(good) {
+				continue
+			}
+			o := find(good)
+			if o == nil {
+				prev.problem("%s not traded at %s", good, r.Destinations[stop])
+				continue
+			}
+			p, known := paid[good]
+			if ok, why := r.Rules[stop].CanSell(o.SellPricePerUnit, p, known); !ok {
+				prev.problem("holding %s: %s", good, why)
+				continue
+			}
+			prev.Sold[good] += qty
+			prev.Revenue += qty * o.SellPricePerUnit
+			delete(cargo, good)
+		}
+
+		// Buy fuel for the next hop
+		from, to := locs[leg.From], locs[leg.To]
+		if from == nil || to == nil {
+			res.Stuck = fmt.Sprintf("unknown location on leg %s -> %s", leg.From, leg.To)
+			break
+		}
+		need := ship.FuelNeeded(from, to)
+		if need > ship.MaxCargo {
+			leg.problem("needs %d fuel, more than the ship can carry", need)
+			res.Stuck = fmt.Sprintf("%s can't carry enough fuel for %s -> %s", ship.Type, leg.From, leg.To)
+			break
+		}
+		if buy := need - cargo["FUEL"]; buy > 0 {
+			fuel := find("FUEL")
+			if fuel == nil {
+				leg.problem("no fuel for sale")
+				res.Stuck = fmt.Sprintf("no fuel for sale at %s", leg.From)
+				break
+			}
+			if buy > space() {
+				leg.problem("no room for %d fuel", buy)
+				res.Stuck = fmt.Sprintf("no room for fuel at %s", leg.From)
+				break
+			}
+			cargo["FUEL"] += buy
+			leg.FuelCost += buy * fuel.PurchasePricePerUnit
+		}
+
+		// Buy cargo
+		for _, p := range r.Cargos[stop].Purchases(space(), offers, r.Rules[stop]) {
+			if p.Skip != "" {
+				leg.problem("not buying %s: %s", p.Good, p.Skip)
+				continue
+			}
+			volume[p.Good] = find(p.Good).VolumePerUnit
+			cargo[p.Good] += p.Qty
+			paid[p.Good] = p.Price
+			leg.Bought[p.Good] += p.Qty
+			leg.Cost += p.Qty * p.Price
+		}
+
+		// Fly on
+		cargo["FUEL"] -= need
+		leg.Fuel += need
+		leg.Runs++
+		now = now.Add(ship.FlightTime(from, to))
+		res.End = now
+		if stop == n-1 {
+			res.Loops++
+		}
+	}
+
+	return res
+}
+
+// Find the ship to simulate with, from the cached ships: the one given, one of
+// the type given, or the first on the route.
+func getSimulationShip(r *route, args []string) (*spacetraders.Ship, error) {
+	var ships []*spacetraders.Ship
+	for _, o := range cache.RestoreObjs(spacetraders.SHIPOBJ) {
+		ships = append(ships, o.(*spacetraders.Ship))
+	}
+
+	if len(args) > 0 {
+		for _, s := range ships {
+			if s.ID == args[0] || s.ShortID == args[0] {
+				return s, nil
+			}
+		}
+		for _, s := range ships {
+			if strings.EqualFold(s.Type, args[0]) {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("no known ship or ship of type %q", args[0])
+	}
+
+	for _, id := range r.shipIDs() {
+		for _, s := range ships {
+			if s.ID == id {
+				return s, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("route %s has no known ships, give a ship type or id to simulate with", r.Name)
+}
+
+func doSimulateRoute(c *spacetraders.Client, args []string) error {
+	orig, err := getRoute(args[0])
+	if err != nil {
+		return err
+	}
+	// Simulate on a copy, as the route can change while it runs
+	r := orig.copyStops(orig.Name)
+	if len(r.Destinations) < 2 {
+		return fmt.Errorf("route %s needs at least 2 stops", r.Name)
+	}
+
+	hours := 24.0
+	if len(args) > 1 {
+		hours, err = strconv.ParseFloat(args[1], 64)
+		if err != nil || hours <= 0 {
+			return fmt.Errorf("invalid number of hours %q", args[1])
+		}
+	}
+
+	// Only what's already known, a simulation doesn't call the API
+	locs := make(map[string]*spacetraders.Location)
+	for _, d := range r.Destinations {
+		l, ok := c.KnownLocation(d)
+		if !ok {
+			return fmt.Errorf("location %q isn't known yet, use ListSystems to load it", d)
+		}
+		locs[d] = l
+	}
+
+	var shipArgs []string
+	if len(args) > 2 {
+		shipArgs = args[2:]
+	}
+	ship, err := getSimulationShip(orig, shipArgs)
+	if err != nil {
+		return err
+	}
+
+	d := time.Duration(hours * float64(time.Hour))
+	res := simulateRoute(r, ship, locs, recordedMarket(spacetraders.GetMarketHistory()), time.Now().Add(-d), d)
+	Out("Simulated %s with a %s:", r.Name, ship.Type)
+	Out(res.String())
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func TestSimulateRoute(t *testing.T) {
+	ship := &spacetraders.Ship{Type: "JW-MK-I", MaxCargo: 50, Speed: 1}
+	locs := map[string]*spacetraders.Location{
+		"OE-A": {Symbol: "OE-A", Type: "MOON", X: 0, Y: 0},
+		"OE-B": {Symbol: "OE-B", Type: "MOON", X: 10, Y: 0},
+	}
+	fuel := spacetraders.Offer{Symbol: "FUEL", VolumePerUnit: 1, PurchasePricePerUnit: 2, SellPricePerUnit: 1, QuantityAvailable: 1000}
+	offer := func(good string, buy, sell int) spacetraders.Offer {
+		return spacetraders.Offer{Symbol: good, VolumePerUnit: 1, PurchasePricePerUnit: buy, SellPricePerUnit: sell, QuantityAvailable: 1000}
+	}
+	offers := map[string][]spacetraders.Offer{
+		"OE-A": {fuel, offer("METALS", 5, 4)},
+		"OE-B": {offer("METALS", 10, 9), offer("FOOD", 10, 8)},
+	}
+	market := func(loc string, at time.Time) ([]spacetraders.Offer, bool) {
+		return offers[loc], true
+	}
+
+	r := newRoute("test")
+	r.AddStop("OE-A", simpleCargo("METALS"), tradeRules{})
+	r.AddStop("OE-B", simpleCargo("FOOD"), tradeRules{MaxBuy: 5})
+
+	start := time.Now()
+	res := simulateRoute(r, ship, locs, market, start, time.Hour)
+
+	// OE-B doesn't sell fuel, so the ship can't get back
+	if !strings.Contains(res.Stuck, "no fuel for sale at OE-B") {
+		t.Errorf("want to be stuck at OE-B, got %s", res)
+	}
+	if res.Legs[0].Sold["METALS"] != 48 || res.Legs[0].Profit() <= 0 {
+		t.Errorf("first leg should sell metals at a profit: %s", res)
+	}
+
+	offers["OE-B"] = append(offers["OE-B"], fuel)
+	res = simulateRoute(r, ship, locs, market, start, time.Hour)
+	if res.Stuck != "" {
+		t.Fatalf("unexpected failure: %s", res)
+	}
+	if res.Loops == 0 || res.Legs[0].Runs < res.Loops {
+		t.Errorf("bad loop count: %s", res)
+	}
+	if res.End.Before(start.Add(time.Hour)) {
+		t.Errorf("stopped before the end: %s", res)
+	}
+	if res.Legs[1].Problems["not buying FOOD: price 10 is above maximum 5"] == 0 {
+		t.Errorf("rules not applied at OE-B: %s", res)
+	}
+	want := 0
+	for _, l := range res.Legs {
+		want += l.Profit()
+	}
+	if res.Profit() != want {
+		t.Errorf("want profit %d, got %d", want, res.Profit())
+	}
+}
+
+func TestDoSimulateRoute(t *testing.T) {
+	defer SetTUI(ui)
+	SetTUI(&recordUI{})
+	defer func() { outputBuffer = []string{} }()
+	defer cache.ClearObjs(spacetraders.SHIPOBJ)
+	cache.StoreObjs(spacetraders.SHIPOBJ, []interface{}{
+		&spacetraders.Ship{ID: "sim-ship", ShortID: "s-1", Type: "JW-MK-I", MaxCargo: 50, Speed: 1},
+	})
+
+	c, calls := fakeAPI(t, map[string]interface{}{"/game/systems": fakeSystems})
+	if _, err := c.ListSystems(); err != nil {
+		t.Fatalf("can't list systems: %v", err)
+	}
+	before := calls()
+
+	r := newRoute("sim-cmd")
+	r.AddStop("OE-PM", simpleCargo("METALS"), tradeRules{})
+	r.AddStop("OE-PM-TR", simpleCargo("NONE"), tradeRules{})
+	r.AddShip("sim-ship")
+	if err := addRoute(r); err != nil {
+		t.Fatalf("can't add route: %v", err)
+	}
+	defer deleteRoute(r.Name)
+
+	tests := []struct {
+		desc    string
+		args    []string
+		wantErr bool
+	}{
+		{desc: "route only", args: []string{"sim-cmd"}},
+		{desc: "hours", args: []string{"sim-cmd", "2"}},
+		{desc: "ship type", args: []string{"sim-cmd", "2", "jw-mk-i"}},
+		{desc: "ship id", args: []string{"sim-cmd", "2", "s-1"}},
+		{desc: "unknown ship", args: []string{"sim-cmd", "2", "GR-MK-III"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			outputBuffer = []string{}
+			err := doSimulateRoute(c, tc.args)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got %q", outputBuffer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("can't simulate: %v", err)
+			}
+			if len(outputBuffer) == 0 || outputBuffer[0] != "Simulated sim-cmd with a JW-MK-I:" {
+				t.Errorf("bad output: %q", outputBuffer)
+			}
+		})
+	}
+	if n := calls() - before; n != 0 {
+		t.Errorf("simulating made %d API calls", n)
+	}
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zigdon/spacetraders"
+)
+
+// A good to buy at a stop, and what percent of the remaining space to use
+// for it. Zero means all of it.
+type cargoSpec struct {
+	Good    string
+	Percent int
+}
+
+func (c cargoSpec) String() string {
+	if c.Percent == 0 {
+		return c.Good
+	}
+	return fmt.Sprintf("%s:%d", c.Good, c.Percent)
+}
+
+// What to buy and sell at a stop. Goods are bought in order of priority.
+// Unless SellAll is set, only the goods in Sell are sold, and anything else
+// is kept for a later stop.
+type stopCargo struct {
+	Buy     []cargoSpec
+	Sell    []string
+	SellAll bool
+}
+
+// A stop that buys a single good (or nothing, for NONE), and sells everything
+func simpleCargo(good string) stopCargo {
+	s := stopCargo{SellAll: true}
+	if good != "NONE" {
+		s.Buy = []cargoSpec{{Good: good}}
+	}
+	return s
+}
+
+// Parse a cargo spec: GOOD[:PERCENT][,GOOD[:PERCENT]...][/GOOD[,GOOD...]]
+// The part before the slash is what to buy, the part after is what to sell.
+// NONE can be used for either part, and if there is no slash everything is
+// sold.
+func parseStopCargo(spec string) (stopCargo, error) {
+	spec = strings.ToUpper(spec)
+	s := stopCargo{SellAll: true}
+	buys := spec
+	if i := strings.Index(spec, "/"); i != -1 {
+		buys = spec[:i]
+		s.SellAll = false
+		for _, g := range strings.Split(spec[i+1:], ",") {
+			if g == "" {
+				return s, fmt.Errorf("empty good in sell list %q", spec)
+			}
+			if g != "NONE" {
+				s.Sell = append(s.Sell, g)
+			}
+		}
+	}
+
+	for _, b := range strings.Split(buys, ",") {
+		if b == "NONE" {
+			continue
+		}
+		c := cargoSpec{Good: b}
+		if i := strings.Index(b, ":"); i != -1 {
+			c.Good = b[:i]
+			pct, err := strconv.Atoi(strings.TrimSuffix(b[i+1:], "%"))
+			if err != nil || pct <= 0 || pct > 100 {
+				return s, fmt.Errorf("invalid percent in %q", b)
+			}
+			c.Percent = pct
+		}
+		if c.Good == "" {
+			return s, fmt.Errorf("empty good in buy list %q", spec)
+		}
+		s.Buy = append(s.Buy, c)
+	}
+
+	return s, nil
+}
+
+// All the goods mentioned in the spec
+func (s stopCargo) Goods() []string {
+	res := []string{}
+	for _, b := range s.Buy {
+		res = append(res, b.Good)
+	}
+	return append(res, s.Sell...)
+}
+
+// Should this good be sold at this stop?
+func (s stopCargo) Sells(good string) bool {
+	if s.SellAll {
+		return true
+	}
+	for _, g := range s.Sell {
+		if g == good {
+			return true
+		}
+	}
+	return false
+}
+
+func (s stopCargo) String() string {
+	buys := []string{}
+	for _, b := range s.Buy {
+		buys = append(buys, b.String())
+	}
+	if len(buys) == 0 {
+		buys = []string{"NONE"}
+	}
+	res := strings.Join(buys, ",")
+	if !s.SellAll {
+		sells := s.Sell
+		if len(sells) == 0 {
+			sells = []string{"NONE"}
+		}
+		res += "/" + strings.Join(sells, ",")
+	}
+	return res
+}
+
+// Routes saved before stops had several goods just have the name of the good
+func (s *stopCargo) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		*s = simpleCargo(legacy)
+		return nil
+	}
+
+	type plain stopCargo
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*s = stopCargo(p)
+
+	return nil
+}
+
+// A planned purchase at a stop
+type purchase struct {
+	Good  string
+	Qty   int
+	Price int
+	Skip  string
+}
+
+// Work out how much of each good to buy with the space available, in order of
+// priority, within the stop's rules. Goods that won't be bought have Skip set
+// to the reason.
+func (s stopCargo) Purchases(space int, market []spacetraders.Offer, rules tradeRules) []purchase {
+	var res []purchase
+	for _, b := range s.Buy {
+		p := purchase{Good: b.Good}
+		var offer *spacetraders.Offer
+		for i, o := range market {
+			if o.Symbol == b.Good {
+				offer = &market[i]
+			}
+		}
+		if offer == nil || offer.VolumePerUnit == 0 {
+			p.Skip = "not on offer"
+			res = append(res, p)
+			continue
+		}
+
+		room := space
+		if b.Percent > 0 {
+			room = space * b.Percent / 100
+		}
+		p.Price = offer.PurchasePricePerUnit
+		p.Qty = room / offer.VolumePerUnit
+		if p.Qty > offer.QuantityAvailable {
+			p.Qty = offer.QuantityAvailable
+		}
+		if p.Qty == 0 {
+			p.Skip = "no room"
+		} else if ok, why := rules.CanBuy(p.Price, p.Qty); !ok {
+			p.Skip = why
+		}
+		if p.Skip != "" {
+			p.Qty = 0
+		}
+		space -= p.Qty * offer.VolumePerUnit
+		res = append(res, p)
+	}
+
+	return res
+}
+
+// Validate and parse a location and cargo spec for a route stop
+func parseStop(c *spacetraders.Client, loc, spec string) (string, stopCargo, error) {
+	l := []string{loc}
+	if err := validate(c, l, []string{"location"}); err != nil {
+		return "", stopCargo{}, err
+	}
+	cargo, err := parseStopCargo(spec)
+	if err != nil {
+		return "", stopCargo{}, err
+	}
+	for i, b := range cargo.Buy {
+		g := []string{b.Good}
+		if err := validate(c, g, []string{"cargo"}); err != nil {
+			return "", stopCargo{}, err
+		}
+		cargo.Buy[i].Good = g[0]
+	}
+	for i, s := range cargo.Sell {
+		g := []string{s}
+		if err := validate(c, g, []string{"cargo"}); err != nil {
+			return "", stopCargo{}, err
+		}
+		cargo.Sell[i] = g[0]
+	}
+
+	return l[0], cargo, nil
+}
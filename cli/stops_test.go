@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestParseStopCargo(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    stopCargo
+		wantErr bool
+	}{
+		{spec: "METALS", want: simpleCargo("METALS")},
+		{spec: "NONE", want: simpleCargo("NONE")},
+		{
+			spec: "METALS:50,DRONES/FUEL,CHEMICALS",
+			want: stopCargo{
+				Buy:  []cargoSpec{{"METALS", 50}, {"DRONES", 0}},
+				Sell: []string{"FUEL", "CHEMICALS"},
+			},
+		},
+		{
+			spec: "NONE/NONE",
+			want: stopCargo{},
+		},
+		{spec: "METALS:0", wantErr: true},
+		{spec: "METALS:abc", wantErr: true},
+		{spec: "METALS/", wantErr: true},
+		{spec: ",METALS", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := parseStopCargo(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("wanted error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("-want +got\n%s", diff)
+			}
+			if got.String() != tc.spec {
+				t.Errorf("bad string: want %q, got %q", tc.spec, got.String())
+			}
+		})
+	}
+}
+
+func TestStopCargoLegacy(t *testing.T) {
+	var got []stopCargo
+	if err := json.Unmarshal([]byte(`["METALS", "NONE"]`), &got); err != nil {
+		t.Fatalf("can't unmarshal legacy cargos: %v", err)
+	}
+	want := []stopCargo{simpleCargo("METALS"), simpleCargo("NONE")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got\n%s", diff)
+	}
+
+	s, _ := parseStopCargo("METALS:25/DRONES")
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("can't marshal: %v", err)
+	}
+	var back stopCargo
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("can't unmarshal: %v", err)
+	}
+	if diff := cmp.Diff(s, back); diff != "" {
+		t.Errorf("round trip: -want +got\n%s", diff)
+	}
+}
+
+func TestPurchases(t *testing.T) {
+	market := []spacetraders.Offer{
+		{Symbol: "METALS", VolumePerUnit: 1, PurchasePricePerUnit: 10, QuantityAvailable: 1000},
+		{Symbol: "DRONES", VolumePerUnit: 2, PurchasePricePerUnit: 50, QuantityAvailable: 15},
+		{Symbol: "FUEL", VolumePerUnit: 1, PurchasePricePerUnit: 2, QuantityAvailable: 1000},
+	}
+	s, _ := parseStopCargo("DRONES,METALS:50,CHEMICALS,FUEL")
+	got := s.Purchases(100, market, tradeRules{})
+	want := []purchase{
+		{Good: "DRONES", Qty: 15, Price: 50},
+		{Good: "METALS", Qty: 35, Price: 10},
+		{Good: "CHEMICALS", Skip: "not on offer"},
+		{Good: "FUEL", Qty: 35, Price: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want +got\n%s", diff)
+	}
+
+	got = s.Purchases(100, market, tradeRules{MaxBuy: 20})
+	if got[0].Skip == "" || got[1].Qty != 50 {
+		t.Errorf("rules not applied: %+v", got)
+	}
+}
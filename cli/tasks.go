@@ -0,0 +1,349 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+	"github.com/zigdon/spacetraders/tasks"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Tasks",
+			Name:    "ListTasks",
+			Usage:   "ListTasks [filter]",
+			Help: "List the background tasks, when they'll next run, how they did last " +
+				"time and how long they take.",
+			Do:      doListTasks,
+			MaxArgs: 1,
+			Aliases: []string{"lsTasks"},
+		},
+		{
+			Section: "Tasks",
+			Name:    "CancelTask",
+			Usage:   "CancelTask <task>",
+			Help:    "Remove a background task. A unique prefix of the task's name is enough.",
+			Do:      doCancelTask,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Tasks",
+			Name:    "RunTask",
+			Usage:   "RunTask <task>",
+			Help:    "Run a background task now. It'll still run again when it's due.",
+			Do:      doRunTask,
+			MinArgs: 1,
+			MaxArgs: 1,
+		},
+		{
+			Section: "Tasks",
+			Name:    "Reschedule",
+			Usage:   "Reschedule <task> <now|duration|HH:MM> [repeat duration|none]",
+			Help: "Change when a background task next runs, either after a duration " +
+				"(e.g. 5m) or at a time of day, and optionally how often it repeats.",
+			Do:      doReschedule,
+			MinArgs: 2,
+			MaxArgs: 3,
+		},
+		{
+			Section: "Tasks",
+			Name:    "Schedule",
+			Usage:   "Schedule <task> [every=duration|none] [cron=M H DoM Mon DoW|none] [jitter=duration] [until=duration|HH:MM|none] [max=N] [when=value>N|none] [timeout=duration|none]",
+			Help: "Show or change how a background task repeats. cron takes a standard " +
+				"5 field cron expression (e.g. cron=5 * * * * for every hour at :05) or " +
+				"@hourly, @daily, @weekly or @monthly. jitter adds a random delay to each " +
+				"run, until and max stop the task after a time or a number of runs, and " +
+				"when skips runs unless a condition on credits or ships is met " +
+				"(e.g. when=credits>=100000). timeout is how long a run can take before " +
+				"it's counted as failed.",
+			Do:      doSchedule,
+			MinArgs: 1,
+			MaxArgs: -1,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+
+	tq := tasks.GetTaskQueue()
+	if err := RegisterPersistence("tasks", tq.Save, tq.Load); err != nil {
+		log.Fatalf("Can't register load/save for tasks: %v", err)
+	}
+
+	for n, f := range map[string]func() (int, error){
+		"credits": cachedCredits,
+		"ships":   cachedShips,
+	} {
+		if err := tasks.RegisterValue(n, f); err != nil {
+			log.Fatalf("Can't register task value %q: %v", n, err)
+		}
+	}
+}
+
+// Values for task conditions, from what was last fetched by the background
+// updates
+func cachedCredits() (int, error) {
+	us := cache.RestoreObjs(spacetraders.USEROBJ)
+	if len(us) == 0 {
+		return 0, fmt.Errorf("no account info yet")
+	}
+	return us[0].(*spacetraders.User).Credits, nil
+}
+
+func cachedShips() (int, error) {
+	return len(cache.RestoreObjs(spacetraders.SHIPOBJ)), nil
+}
+
+// Find a task by its name, or a unique prefix of it
+func findTask(name string) (*tasks.TaskInfo, error) {
+	var matches []tasks.TaskInfo
+	for _, t := range tasks.GetTaskQueue().List() {
+		if t.Key == name {
+			return &t, nil
+		}
+		if strings.HasPrefix(t.Key, name) {
+			matches = append(matches, t)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no task found matching %q", name)
+	case 1:
+		return &matches[0], nil
+	}
+	var keys []string
+	for _, m := range matches {
+		keys = append(keys, m.Key)
+	}
+	return nil, fmt.Errorf("%q could mean %s", name, strings.Join(keys, ", "))
+}
+
+// Parse when a task should run: now, after a duration, or at a time of day
+func parseWhen(when string, now time.Time) (time.Time, error) {
+	if strings.ToLower(when) == "now" {
+		return now, nil
+	}
+	if d, err := time.ParseDuration(when); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.ParseInLocation("15:04", when, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't now, a duration or HH:MM", when)
+	}
+	res := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if res.Before(now) {
+		res = res.Add(24 * time.Hour)
+	}
+	return res, nil
+}
+
+// Update a schedule from a list of key=value settings. cron takes the four
+// fields after it too, unless it's a shortcut like @hourly.
+func parseSchedule(s *tasks.Schedule, settings []string, now time.Time) error {
+	for i := 0; i < len(settings); i++ {
+		kv := strings.SplitN(settings[i], "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("settings must look like key=value, not %q", settings[i])
+		}
+		key, val := strings.ToLower(kv[0]), kv[1]
+		none := strings.ToLower(val) == "none"
+		switch key {
+		case "every":
+			s.Every = 0
+			if !none {
+				d, err := time.ParseDuration(val)
+				if err != nil || d < time.Second {
+					return fmt.Errorf("invalid repeat %q, must be at least 1s", val)
+				}
+				s.Every = d
+				s.Cron = ""
+			}
+		case "cron":
+			s.Cron = ""
+			if none {
+				continue
+			}
+			if !strings.HasPrefix(val, "@") {
+				if i+4 >= len(settings) {
+					return fmt.Errorf("cron needs 5 fields")
+				}
+				val = strings.Join(append([]string{val}, settings[i+1:i+5]...), " ")
+				i += 4
+			}
+			s.Cron = val
+			s.Every = 0
+		case "jitter":
+			s.Jitter = 0
+			if !none {
+				d, err := time.ParseDuration(val)
+				if err != nil || d < 0 {
+					return fmt.Errorf("invalid jitter %q", val)
+				}
+				s.Jitter = d
+			}
+		case "until":
+			s.Until = time.Time{}
+			if !none {
+				t, err := parseWhen(val, now)
+				if err != nil {
+					return err
+				}
+				s.Until = t
+			}
+		case "timeout":
+			s.Timeout = 0
+			if !none {
+				d, err := time.ParseDuration(val)
+				if err != nil || d < time.Second {
+					return fmt.Errorf("invalid timeout %q, must be at least 1s", val)
+				}
+				s.Timeout = d
+			}
+		case "max":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid number of runs %q", val)
+			}
+			s.Max = n
+		case "when":
+			s.When = ""
+			if !none {
+				s.When = val
+			}
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+
+	return s.Validate()
+}
+
+// A one line summary of each task, for the tasks window
+func TaskSummary() string {
+	var res []string
+	for _, t := range tasks.GetTaskQueue().List() {
+		line := fmt.Sprintf("%s %s", t.When.Format("15:04:05"), t.Key)
+		if t.LastErr != "" {
+			line += " (!)"
+		}
+		res = append(res, line)
+	}
+	return strings.Join(res, "\n")
+}
+
+func doListTasks(c *spacetraders.Client, args []string) error {
+	list := tasks.GetTaskQueue().List()
+	found := 0
+	for _, t := range list {
+		if len(args) > 0 && !strings.Contains(strings.ToLower(t.Key), strings.ToLower(args[0])) {
+			continue
+		}
+		Out(t.String())
+		found++
+	}
+	if found == 0 {
+		Out("No tasks found.")
+	}
+	if len(args) == 0 {
+		Out(tasks.GetTaskQueue().Stats().String())
+	}
+
+	return nil
+}
+
+func doCancelTask(c *spacetraders.Client, args []string) error {
+	t, err := findTask(args[0])
+	if err != nil {
+		return err
+	}
+	if err := tasks.Cancel(t.Key); err != nil {
+		return err
+	}
+	Out("Cancelled %s", t.Key)
+
+	return nil
+}
+
+func doRunTask(c *spacetraders.Client, args []string) error {
+	t, err := findTask(args[0])
+	if err != nil {
+		return err
+	}
+	// Scheduled commands wait for this one to finish, so they can't run here
+	if t.Kind == commandKind || t.Kind == arrivalKind {
+		go tasks.Run(t.Key)
+		Out("Started %s, its output will be in the messages window", t.Key)
+		return nil
+	}
+	msg, err := tasks.Run(t.Key)
+	if msg != "" {
+		Out(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("task %s failed: %v", t.Key, err)
+	}
+	Out("Ran %s", t.Key)
+
+	return nil
+}
+
+func doReschedule(c *spacetraders.Client, args []string) error {
+	t, err := findTask(args[0])
+	if err != nil {
+		return err
+	}
+	when, err := parseWhen(args[1], time.Now())
+	if err != nil {
+		return err
+	}
+	repeat := t.Schedule.Every
+	if len(args) > 2 {
+		if strings.ToLower(args[2]) == "none" {
+			repeat = 0
+		} else if repeat, err = time.ParseDuration(args[2]); err != nil || repeat < time.Second {
+			return fmt.Errorf("invalid repeat %q, must be at least 1s", args[2])
+		}
+	}
+
+	if err := tasks.GetTaskQueue().Reschedule(t.Key, when, repeat); err != nil {
+		return err
+	}
+	t.When = when
+	t.Schedule.Every = repeat
+	t.Schedule.Cron = ""
+	Out(t.String())
+
+	return nil
+}
+
+func doSchedule(c *spacetraders.Client, args []string) error {
+	t, err := findTask(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		Out(t.String())
+		return nil
+	}
+
+	sched := t.Schedule
+	if err := parseSchedule(&sched, args[1:], time.Now()); err != nil {
+		return err
+	}
+	if err := tasks.GetTaskQueue().SetSchedule(t.Key, sched); err != nil {
+		return err
+	}
+	if t, err = findTask(t.Key); err != nil {
+		return err
+	}
+	Out(t.String())
+
+	return nil
+}
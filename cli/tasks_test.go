@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders/tasks"
+)
+
+func TestParseWhen(t *testing.T) {
+	now := time.Date(2021, 10, 9, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		when    string
+		want    time.Time
+		wantErr bool
+	}{
+		{when: "now", want: now},
+		{when: "5m", want: now.Add(5 * time.Minute)},
+		{when: "13:00", want: time.Date(2021, 10, 9, 13, 0, 0, 0, time.Local)},
+		{when: "12:00", want: time.Date(2021, 10, 10, 12, 0, 0, 0, time.Local)},
+		{when: "soon", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseWhen(tc.when, now)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: wanted error, got %s", tc.when, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.when, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%s: want %s, got %s", tc.when, tc.want, got)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2021, 10, 9, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		desc     string
+		start    tasks.Schedule
+		settings string
+		want     tasks.Schedule
+		wantErr  bool
+	}{
+		{
+			desc:     "cron",
+			start:    tasks.Schedule{Every: time.Minute},
+			settings: "cron=5 * * * * jitter=30s max=3",
+			want:     tasks.Schedule{Cron: "5 * * * *", Jitter: 30 * time.Second, Max: 3},
+		},
+		{
+			desc:     "shortcut",
+			settings: "cron=@daily when=credits>1000 until=1h",
+			want:     tasks.Schedule{Cron: "@daily", When: "credits>1000", Until: now.Add(time.Hour)},
+		},
+		{
+			desc:     "clear",
+			start:    tasks.Schedule{Cron: "@daily", When: "ships<3", Until: now},
+			settings: "every=10m cron=none when=none until=none",
+			want:     tasks.Schedule{Every: 10 * time.Minute},
+		},
+		{desc: "short cron", settings: "cron=5 * *", wantErr: true},
+		{desc: "bad cron", settings: "cron=a b c d e", wantErr: true},
+		{desc: "bad condition", settings: "when=credits", wantErr: true},
+		{desc: "unknown value", settings: "when=fuel>10", wantErr: true},
+		{desc: "fast", settings: "every=1ms", wantErr: true},
+		{desc: "unknown", settings: "often=yes", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got := tc.start
+		err := parseSchedule(&got, strings.Split(tc.settings, " "), now)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: wanted error, got %+v", tc.desc, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: -want +got\n%s", tc.desc, diff)
+		}
+	}
+}
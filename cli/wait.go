@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zigdon/spacetraders"
+)
+
+func init() {
+	for _, c := range []cmd{
+		{
+			Section: "Flight Plans",
+			Name:    "Wait",
+			Usage:   "Wait <all|flightPlanID|ship>...",
+			Help: "Wait in the background until the flights arrive, showing how long is " +
+				"left. Press Esc or use Cancel to stop waiting.",
+			Do:         doWaitForFlight,
+			MinArgs:    1,
+			MaxArgs:    -1,
+			NoSchedule: true,
+		},
+		{
+			Section:    "Flight Plans",
+			Name:       "Cancel",
+			Usage:      "Cancel",
+			Help:       "Stop waiting for flights",
+			Do:         doCancelWait,
+			NoSchedule: true,
+		},
+	} {
+		if err := Register(c); err != nil {
+			log.Fatalf("Can't register %q: %v", c.Name, err)
+		}
+	}
+}
+
+const (
+	// How often to show how long is left
+	waitReport = time.Minute
+	// How many arrivals can queue up before the wait sees them
+	waitBuffer = 100
+)
+
+// Flights being waited for in the background
+type wait struct {
+	flights map[string]spacetraders.FlightPlan
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// The countdown for the flights that haven't arrived yet
+func (w *wait) countdown(now time.Time) string {
+	var fps []spacetraders.FlightPlan
+	for _, fp := range w.flights {
+		fps = append(fps, fp)
+	}
+	return waitCountdown(fps, now)
+}
+
+var (
+	waitMu  sync.Mutex
+	waiting *wait
+)
+
+// Show a line in the main window from outside a command
+func waitMsg(format string, args ...interface{}) {
+	ui.PrintMsg("main", "-", format, args...)
+}
+
+// How long is left on each flight, soonest first
+func waitCountdown(flights []spacetraders.FlightPlan, now time.Time) string {
+	fps := append([]spacetraders.FlightPlan{}, flights...)
+	sort.Slice(fps, func(i, j int) bool { return fps[i].ArrivesAt.Before(fps[j].ArrivesAt) })
+
+	var res []string
+	for _, fp := range fps {
+		left := fp.ArrivesAt.Sub(now).Truncate(time.Second)
+		if left > 0 {
+			res = append(res, fmt.Sprintf("%s (%s) in %s", fp.ShortID, fp.ShortShipID, left))
+		} else {
+			res = append(res, fmt.Sprintf("%s (%s) due, checking", fp.ShortID, fp.ShortShipID))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// Find the flights to wait for, from flight plans or ships, or all ships in
+// flight
+func waitFlights(c *spacetraders.Client, args []string) ([]spacetraders.FlightPlan, error) {
+	ships, err := c.MyShips()
+	if err != nil {
+		return nil, fmt.Errorf("can't list ships: %v", err)
+	}
+	flying := make(map[string]bool)
+	for _, s := range ships {
+		if s.FlightPlanID != "" {
+			flying[s.FlightPlanID] = true
+		}
+	}
+
+	var ids []string
+	if len(args) == 1 && strings.ToLower(args[0]) == "all" {
+		for _, s := range ships {
+			if s.FlightPlanID != "" {
+				ids = append(ids, s.FlightPlanID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no ships are in flight")
+		}
+	} else {
+	args:
+		for _, a := range args {
+			for _, s := range ships {
+				if s.ID != a && s.ShortID != a {
+					continue
+				}
+				if s.FlightPlanID == "" {
+					return nil, fmt.Errorf("%s isn't in flight", s.ShortID)
+				}
+				ids = append(ids, s.FlightPlanID)
+				continue args
+			}
+			ids = append(ids, a)
+		}
+	}
+
+	var res []spacetraders.FlightPlan
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		fp, err := c.ShowFlight(id)
+		if err != nil {
+			return nil, fmt.Errorf("can't find a ship or flight plan %q: %v", id, err)
+		}
+		if seen[fp.ID] {
+			continue
+		}
+		seen[fp.ID] = true
+		if !flying[fp.ID] {
+			return nil, fmt.Errorf("flight %s (%s) already arrived", fp.ShortID, fp.ID)
+		}
+		res = append(res, *fp)
+	}
+
+	return res, nil
+}
+
+// Wait for flights in the background, replacing any earlier wait. Arrivals are
+// read from the channel, and unsub is called once the wait is over.
+func startWait(flights []spacetraders.FlightPlan, arrived <-chan spacetraders.Event, unsub func()) *wait {
+	w := &wait{
+		flights: make(map[string]spacetraders.FlightPlan),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, fp := range flights {
+		w.flights[fp.ID] = fp
+	}
+
+	waitMu.Lock()
+	if waiting != nil {
+		close(waiting.stop)
+	}
+	waiting = w
+	waitMu.Unlock()
+
+	go func() {
+		defer close(w.done)
+		defer unsub()
+		defer func() {
+			waitMu.Lock()
+			if waiting == w {
+				waiting = nil
+			}
+			waitMu.Unlock()
+		}()
+
+		tick := time.NewTicker(waitReport)
+		defer tick.Stop()
+		for len(w.flights) > 0 {
+			select {
+			case <-w.stop:
+				waitMsg("Stopped waiting for %s", w.countdown(time.Now()))
+				return
+			case e := <-arrived:
+				if e.Flight == nil {
+					continue
+				}
+				if _, ok := w.flights[e.Flight.ID]; !ok {
+					continue
+				}
+				delete(w.flights, e.Flight.ID)
+				waitMsg("%s", e.Summary())
+				if len(w.flights) > 0 {
+					waitMsg("... still waiting for %s", w.countdown(time.Now()))
+				}
+			case now := <-tick.C:
+				waitMsg("... waiting for %s", w.countdown(now))
+			}
+		}
+		if len(flights) > 1 {
+			waitMsg("All %d flights arrived!", len(flights))
+		}
+	}()
+
+	return w
+}
+
+func doWaitForFlight(c *spacetraders.Client, args []string) error {
+	// Listen before looking up the flights, so a quick arrival isn't missed
+	arrived := make(chan spacetraders.Event, waitBuffer)
+	unsub := spacetraders.GetEventBus().Subscribe(func(e spacetraders.Event) {
+		select {
+		case arrived <- e:
+		default:
+		}
+	}, spacetraders.ShipArrived)
+
+	flights, err := waitFlights(c, args)
+	if err != nil {
+		unsub()
+		return err
+	}
+
+	Out("Waiting for %s. Press Esc or use Cancel to stop.", waitCountdown(flights, time.Now()))
+	startWait(flights, arrived, unsub)
+
+	return nil
+}
+
+func doCancelWait(c *spacetraders.Client, args []string) error {
+	waitMu.Lock()
+	w := waiting
+	waiting = nil
+	if w != nil {
+		close(w.stop)
+	}
+	waitMu.Unlock()
+	if w == nil {
+		return fmt.Errorf("not waiting for any flights")
+	}
+	<-w.done
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestWaitCountdown(t *testing.T) {
+	now := time.Now()
+	f1 := spacetraders.FlightPlan{ShortID: "f-1", ShortShipID: "s-1", ArrivesAt: now.Add(90 * time.Second)}
+	f2 := spacetraders.FlightPlan{ShortID: "f-2", ShortShipID: "s-2", ArrivesAt: now.Add(-time.Second)}
+	tests := []struct {
+		desc    string
+		flights []spacetraders.FlightPlan
+		want    string
+	}{
+		{desc: "one", flights: []spacetraders.FlightPlan{f1}, want: "f-1 (s-1) in 1m30s"},
+		{desc: "soonest first", flights: []spacetraders.FlightPlan{f1, f2}, want: "f-2 (s-2) due, checking, f-1 (s-1) in 1m30s"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := waitCountdown(tc.flights, now); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStartWait(t *testing.T) {
+	rec := &recordUI{}
+	defer SetTUI(ui)
+	SetTUI(rec)
+
+	f1 := spacetraders.FlightPlan{ID: "flight-1", ShortID: "f-1", ShortShipID: "s-1", ArrivesAt: time.Now().Add(time.Hour)}
+	f2 := spacetraders.FlightPlan{ID: "flight-2", ShortID: "f-2", ShortShipID: "s-2", ArrivesAt: time.Now().Add(2 * time.Hour)}
+	arrived := make(chan spacetraders.Event, 3)
+	unsubbed := false
+	w := startWait([]spacetraders.FlightPlan{f1, f2}, arrived, func() { unsubbed = true })
+	arrived <- spacetraders.Event{Type: spacetraders.ShipArrived, Ship: "s-3", Location: "X1", Flight: &spacetraders.FlightPlan{ID: "flight-3"}}
+	arrived <- spacetraders.Event{Type: spacetraders.ShipArrived, Ship: "s-1", Location: "OE-PM", Flight: &f1}
+	arrived <- spacetraders.Event{Type: spacetraders.ShipArrived, Ship: "s-2", Location: "OE-NY", Flight: &f2}
+	<-w.done
+
+	want := []string{
+		"f-1: s-1 arrived at OE-PM with empty",
+		"... still waiting for f-2 (s-2)",
+		"f-2: s-2 arrived at OE-NY with empty",
+		"All 2 flights arrived!",
+	}
+	// The countdown depends on how long the test took
+	opt := cmp.Transformer("countdown", func(s string) string {
+		if i := strings.Index(s, ") in "); i >= 0 {
+			return s[:i+1]
+		}
+		return s
+	})
+	if diff := cmp.Diff(want, rec.msgs, opt); diff != "" {
+		t.Errorf("bad messages: -want +got\n%s", diff)
+	}
+	if !unsubbed {
+		t.Errorf("didn't unsubscribe after the wait")
+	}
+	if err := doCancelWait(nil, nil); err == nil {
+		t.Errorf("cancelled a finished wait")
+	}
+
+	rec.msgs = nil
+	startWait([]spacetraders.FlightPlan{f1}, make(chan spacetraders.Event), func() {})
+	if err := doCancelWait(nil, nil); err != nil {
+		t.Errorf("can't cancel: %v", err)
+	}
+	if len(rec.msgs) != 1 || !strings.HasPrefix(rec.msgs[0], "Stopped waiting for f-1 (s-1) in ") {
+		t.Errorf("bad messages after cancel: %q", rec.msgs)
+	}
+}
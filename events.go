@@ -0,0 +1,348 @@
+package spacetraders
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	ShipArrived    EventType = "ShipArrived"
+	ShipDeparted   EventType = "ShipDeparted"
+	CargoChanged   EventType = "CargoChanged"
+	CreditsChanged EventType = "CreditsChanged"
+	LoanTaken      EventType = "LoanTaken"
+	LoanDue        EventType = "LoanDue"
+	OrderFilled    EventType = "OrderFilled"
+	FlightCreated  EventType = "FlightCreated"
+)
+
+var AllEvents = []EventType{
+	ShipArrived, ShipDeparted, CargoChanged, CreditsChanged,
+	LoanTaken, LoanDue, OrderFilled, FlightCreated,
+}
+
+const (
+	// How many events to keep for Recent
+	eventHistory = 100
+	// How many events can wait for a subscriber before new ones are dropped
+	subscriberBuffer = 100
+	// How long before a loan is due to publish LoanDue
+	loanDueWarning = 24 * time.Hour
+)
+
+// A change in the game state. Only the fields that make sense for the type
+// are set.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	ShipID   string
+	Ship     string // short ID
+	Location string
+	Flight   *FlightPlan
+	Cargo    []Cargo
+	Order    *Order
+	Buy      bool
+	Loan     *Loan
+	Credits  int
+	Change   int
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s: %s", e.Time.Format("15:04:05"), e.Type, e.Summary())
+}
+
+// What happened, without the time and type
+func (e Event) Summary() string {
+	var msg string
+	switch e.Type {
+	case ShipArrived:
+		msg = fmt.Sprintf("%s arrived at %s with %s", e.Ship, e.Location, cargoString(e.Cargo))
+		if e.Flight != nil && e.Flight.ShortID != "" {
+			msg = e.Flight.ShortID + ": " + msg
+		}
+	case ShipDeparted:
+		msg = fmt.Sprintf("%s left %s", e.Ship, e.Location)
+	case CargoChanged:
+		msg = fmt.Sprintf("%s cargo is now %s", e.Ship, cargoString(e.Cargo))
+	case CreditsChanged:
+		msg = fmt.Sprintf("credits %+d, now %d", e.Change, e.Credits)
+	case LoanTaken:
+		msg = fmt.Sprintf("took loan %s of %d, due %s", e.Loan.ShortID, e.Loan.Amount, e.Loan.Due.Local().Format("2006-01-02 15:04"))
+	case LoanDue:
+		msg = fmt.Sprintf("loan %s of %d is due in %s", e.Loan.ShortID, e.Loan.RepaymentAmount, time.Until(e.Loan.Due).Truncate(time.Minute))
+	case OrderFilled:
+		verb := "sold"
+		if e.Buy {
+			verb = "bought"
+		}
+		msg = fmt.Sprintf("%s %s %d of %s for %d at %s", e.Ship, verb, e.Order.Quantity, e.Order.Good, e.Order.Total, e.Location)
+	case FlightCreated:
+		msg = fmt.Sprintf("%s: %s flying %s->%s, arriving in %s", e.Flight.ShortID, e.Ship,
+			e.Flight.Departure, e.Flight.Destination, e.Flight.ArrivesAt.Sub(e.Time).Truncate(time.Second))
+	default:
+		msg = string(e.Type)
+	}
+	return msg
+}
+
+func cargoString(cargo []Cargo) string {
+	if len(cargo) == 0 {
+		return "empty"
+	}
+	res := ""
+	for i, c := range cargo {
+		if i > 0 {
+			res += ", "
+		}
+		res += fmt.Sprintf("%d %s", c.Quantity, c.Good)
+	}
+	return res
+}
+
+type subscriber struct {
+	// nil means all types
+	types map[EventType]bool
+	ch    chan Event
+}
+
+// Delivers events to anything that subscribed to them
+type EventBus struct {
+	mu     sync.Mutex
+	subs   map[int]*subscriber
+	nextID int
+	recent []Event
+}
+
+var events = NewEventBus()
+
+func GetEventBus() *EventBus {
+	return events
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]*subscriber)}
+}
+
+// Call f with each event of the given types, or all events if none are
+// given. Events are delivered in order on a goroutine for each subscriber, so
+// f can take its time and use the Client. Returns a function that ends the
+// subscription.
+func (b *EventBus) Subscribe(f func(Event), types ...EventType) func() {
+	s := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	if len(types) > 0 {
+		s.types = make(map[EventType]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = s
+	b.mu.Unlock()
+
+	go func() {
+		for e := range s.ch {
+			f(e)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+// Send an event to everyone who subscribed to its type. Subscribers that fall
+// too far behind miss events rather than holding up the caller.
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recent = append(b.recent, e)
+	if len(b.recent) > eventHistory {
+		b.recent = b.recent[len(b.recent)-eventHistory:]
+	}
+	for _, s := range b.subs {
+		if s.types != nil && !s.types[e.Type] {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			log.Printf("Subscriber too slow, dropping %s event", e.Type)
+		}
+	}
+}
+
+// The latest events of the given types, or of all types, oldest first
+func (b *EventBus) Recent(types ...EventType) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var res []Event
+	for _, e := range b.recent {
+		if len(types) == 0 {
+			res = append(res, e)
+			continue
+		}
+		for _, t := range types {
+			if e.Type == t {
+				res = append(res, e)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// The events for a ship changing from old to new
+func shipEvents(old, new *Ship) []Event {
+	var res []Event
+	ev := func(t EventType) Event {
+		return Event{Type: t, ShipID: new.ID, Ship: new.ShortID, Cargo: new.Cargo}
+	}
+	switch {
+	case old.FlightPlanID != "" && new.FlightPlanID == "":
+		e := ev(ShipArrived)
+		e.Location = new.LocationName
+		e.Flight = &FlightPlan{ID: old.FlightPlanID, ShortID: old.ShortFlightPlanID, ShipID: new.ID, Destination: new.LocationName}
+		res = append(res, e)
+	case old.FlightPlanID == "" && new.FlightPlanID != "":
+		e := ev(ShipDeparted)
+		e.Location = old.LocationName
+		res = append(res, e)
+	}
+	if !reflect.DeepEqual(old.Cargo, new.Cargo) && (len(old.Cargo) > 0 || len(new.Cargo) > 0) {
+		res = append(res, ev(CargoChanged))
+	}
+	return res
+}
+
+// Publish the changes to a ship. Arrivals go through the watcher, so each
+// flight is only announced once.
+func publishShipEvents(es []Event) {
+	for _, e := range es {
+		if e.Type == ShipArrived {
+			arrivals.arrived(e)
+			continue
+		}
+		events.Publish(e)
+	}
+}
+
+// Replace the cached copy of a ship, publishing what changed. Flight details
+// the API doesn't return are kept.
+func (c *Client) updateShip(s Ship) {
+	c.shipsMu.Lock()
+	defer c.shipsMu.Unlock()
+	var so []interface{}
+	found := false
+	for _, o := range c.cache.RestoreObjs(SHIPOBJ) {
+		old := o.(*Ship)
+		if old.ID != s.ID {
+			so = append(so, old)
+			continue
+		}
+		found = true
+		s.ShortID = old.ShortID
+		if s.FlightPlanID == old.FlightPlanID {
+			s.ShortFlightPlanID = old.ShortFlightPlanID
+			s.FlightPlanDest = old.FlightPlanDest
+		}
+		publishShipEvents(shipEvents(old, &s))
+		so = append(so, &s)
+	}
+	if found {
+		c.cache.StoreObjs(SHIPOBJ, so)
+	}
+}
+
+// Publish a purchase or sale, and the changes to the ship and credits
+func (c *Client) orderFilled(o Order, ship Ship, credits int, buy bool) {
+	events.Publish(Event{
+		Type:     OrderFilled,
+		ShipID:   ship.ID,
+		Ship:     makeShort(SHIPS, ship.ID),
+		Location: ship.LocationName,
+		Order:    &o,
+		Buy:      buy,
+	})
+	c.updateShip(ship)
+	c.setCredits(credits)
+}
+
+// Publish a new flight, and mark the cached ship as having left so it isn't
+// published again when the ships are next listed
+func (c *Client) flightCreated(fp FlightPlan) {
+	events.Publish(Event{
+		Type:     FlightCreated,
+		ShipID:   fp.ShipID,
+		Ship:     fp.ShortShipID,
+		Location: fp.Destination,
+		Flight:   &fp,
+	})
+
+	c.shipsMu.Lock()
+	var ship Ship
+	found := false
+	for _, o := range c.cache.RestoreObjs(SHIPOBJ) {
+		if s := o.(*Ship); s.ID == fp.ShipID {
+			ship, found = *s, true
+		}
+	}
+	c.shipsMu.Unlock()
+	if !found {
+		return
+	}
+	ship.FlightPlanID = fp.ID
+	ship.ShortFlightPlanID = fp.ShortID
+	ship.FlightPlanDest = fp.Destination
+	c.updateShip(ship)
+}
+
+// Record a new credit balance, publishing the change. Does nothing until the
+// account has been loaded, as there's nothing to compare to.
+func (c *Client) setCredits(credits int) {
+	c.shipsMu.Lock()
+	defer c.shipsMu.Unlock()
+	us := c.cache.RestoreObjs(USEROBJ)
+	if len(us) == 0 {
+		return
+	}
+	old := us[0].(*User)
+	if old.Credits == credits {
+		return
+	}
+	u := *old
+	u.Credits = credits
+	c.cache.StoreObjs(USEROBJ, []interface{}{&u})
+	events.Publish(Event{Type: CreditsChanged, Credits: credits, Change: credits - old.Credits})
+}
+
+// Publish LoanDue once for each loan that's due soon
+func (c *Client) checkLoans(loans []Loan) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, l := range loans {
+		if strings.Contains(l.Status, "PAID") || c.loansDue[l.ID] || time.Until(l.Due) > loanDueWarning {
+			continue
+		}
+		c.loansDue[l.ID] = true
+		events.Publish(Event{Type: LoanDue, Loan: &loans[i]})
+	}
+}
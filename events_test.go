@@ -0,0 +1,86 @@
+package spacetraders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEventBus(t *testing.T) {
+	b := NewEventBus()
+	all := make(chan Event, 10)
+	credits := make(chan Event, 10)
+	stopAll := b.Subscribe(func(e Event) { all <- e })
+	defer b.Subscribe(func(e Event) { credits <- e }, CreditsChanged)()
+
+	b.Publish(Event{Type: ShipArrived, Ship: "s-1"})
+	b.Publish(Event{Type: CreditsChanged, Credits: 10})
+	stopAll()
+	stopAll()
+	b.Publish(Event{Type: CreditsChanged, Credits: 20})
+
+	get := func(ch chan Event) []EventType {
+		var res []EventType
+		for {
+			select {
+			case e := <-ch:
+				if e.Time.IsZero() {
+					t.Errorf("%s published without a time", e.Type)
+				}
+				res = append(res, e.Type)
+			case <-time.After(50 * time.Millisecond):
+				return res
+			}
+		}
+	}
+	if diff := cmp.Diff([]EventType{ShipArrived, CreditsChanged}, get(all)); diff != "" {
+		t.Errorf("bad events for all: -want +got\n%s", diff)
+	}
+	if diff := cmp.Diff([]EventType{CreditsChanged, CreditsChanged}, get(credits)); diff != "" {
+		t.Errorf("bad events for credits: -want +got\n%s", diff)
+	}
+	if got := len(b.Recent()); got != 3 {
+		t.Errorf("want 3 recent events, got %d", got)
+	}
+	if got := b.Recent(ShipArrived); len(got) != 1 || got[0].Ship != "s-1" {
+		t.Errorf("bad recent arrivals: %v", got)
+	}
+}
+
+func TestShipEvents(t *testing.T) {
+	docked := Ship{ID: "ship", ShortID: "s-1", LocationName: "OE-PM", Cargo: []Cargo{{Good: "FUEL", Quantity: 10}}}
+	flying := Ship{ID: "ship", ShortID: "s-1", FlightPlanID: "flight", Cargo: []Cargo{{Good: "FUEL", Quantity: 8}}}
+	loaded := docked
+	loaded.Cargo = []Cargo{{Good: "FUEL", Quantity: 10}, {Good: "METALS", Quantity: 5}}
+	arrived := docked
+	arrived.LocationName = "OE-PM-TR"
+	arrived.Cargo = flying.Cargo
+
+	tests := []struct {
+		desc     string
+		old, new Ship
+		want     []EventType
+	}{
+		{desc: "nothing", old: docked, new: docked},
+		{desc: "departed", old: docked, new: flying, want: []EventType{ShipDeparted, CargoChanged}},
+		{desc: "arrived", old: flying, new: arrived, want: []EventType{ShipArrived}},
+		{desc: "bought", old: docked, new: loaded, want: []EventType{CargoChanged}},
+	}
+
+	for _, tc := range tests {
+		var got []EventType
+		for _, e := range shipEvents(&tc.old, &tc.new) {
+			got = append(got, e.Type)
+			if e.Type == ShipArrived && (e.Location != "OE-PM-TR" || e.Flight.ID != "flight") {
+				t.Errorf("%s: bad arrival %+v", tc.desc, e)
+			}
+			if e.Type == ShipDeparted && e.Location != "OE-PM" {
+				t.Errorf("%s: bad departure %+v", tc.desc, e)
+			}
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: -want +got\n%s", tc.desc, diff)
+		}
+	}
+}
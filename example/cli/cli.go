@@ -37,8 +37,8 @@ func (s *stdoutUI) PrintMsg(_ string, prefix string, format string, args ...inte
 	}
 }
 
-func (s *stdoutUI) Msg(string, ...interface{}) {
-	log.Fatal("Not implemented")
+func (s *stdoutUI) Msg(format string, args ...interface{}) {
+	s.PrintMsg("msgs", "-", format, args...)
 }
 
 func (s *stdoutUI) Toggle(string) error {
@@ -0,0 +1,262 @@
+package spacetraders
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// How many observations to keep per ship and destination type, and how many
+// are needed before they override the table.
+const (
+	maxFuelSamples = 50
+	minFuelSamples = 3
+)
+
+// A row in the fuel table. Fuel needed for a flight is
+// round(distance / DistancePerFuel) + Extra.
+// An empty ShipType or DestType matches anything.
+type FuelRate struct {
+	ShipType        string  `json:"shipType"`
+	DestType        string  `json:"destType"`
+	DistancePerFuel float64 `json:"distancePerFuel"`
+	Extra           int     `json:"extra"`
+}
+
+// Initial values, from
+// https://discord.com/channels/792864705139048469/852291054957887498/852292011024187442
+var defaultFuelTable = []FuelRate{
+	{"", "", 7.5, 1},
+	{"", "PLANET", 7.5, 3},
+	{"HM-MK-III", "", 10, 1},
+	{"HM-MK-III", "PLANET", 10, 2},
+	{"GR-MK-II", "PLANET", 7.5, 4},
+	{"GR-MK-III", "PLANET", 7.5, 5},
+}
+
+type fuelSample struct {
+	Distance float64 `json:"distance"`
+	Fuel     int     `json:"fuel"`
+}
+
+type FuelModel struct {
+	mu      sync.Mutex
+	table   map[string]FuelRate
+	samples map[string][]fuelSample
+
+	// Called when a flight used a different amount of fuel than predicted
+	OnDiverge func(shipType, destType string, dist float64, predicted, actual int)
+}
+
+var fuelModel = NewFuelModel()
+
+func NewFuelModel() *FuelModel {
+	m := &FuelModel{
+		table:   make(map[string]FuelRate),
+		samples: make(map[string][]fuelSample),
+	}
+	for _, r := range defaultFuelTable {
+		m.table[fuelKey(r.ShipType, r.DestType)] = r
+	}
+	return m
+}
+
+func GetFuelModel() *FuelModel {
+	return fuelModel
+}
+
+func fuelKey(shipType, destType string) string {
+	return strings.ToUpper(shipType) + "/" + strings.ToUpper(destType)
+}
+
+// Replace the fuel table with one loaded from a JSON file containing a list of
+// FuelRates
+func (m *FuelModel) LoadTable(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read fuel table from %q: %v", path, err)
+	}
+	var rates []FuelRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return fmt.Errorf("can't decode fuel table %q: %v", path, err)
+	}
+
+	table := make(map[string]FuelRate)
+	for _, r := range rates {
+		if r.DistancePerFuel <= 0 {
+			return fmt.Errorf("invalid distancePerFuel for %s/%s: %v", r.ShipType, r.DestType, r.DistancePerFuel)
+		}
+		table[fuelKey(r.ShipType, r.DestType)] = r
+	}
+	if _, ok := table[fuelKey("", "")]; !ok {
+		return fmt.Errorf("fuel table %q has no default row", path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.table = table
+	log.Printf("Loaded %d fuel rates from %q", len(table), path)
+
+	return nil
+}
+
+// Find the most specific table row for a flight
+func (m *FuelModel) rate(shipType, destType string) FuelRate {
+	for _, k := range []string{
+		fuelKey(shipType, destType),
+		fuelKey(shipType, ""),
+		fuelKey("", destType),
+	} {
+		if r, ok := m.table[k]; ok {
+			return r
+		}
+	}
+	return m.table[fuelKey("", "")]
+}
+
+func (m *FuelModel) predict(shipType, destType string, dist float64) int {
+	if a, b, ok := fitSamples(m.samples[fuelKey(shipType, destType)]); ok {
+		return int(math.Max(0, math.Round(a*dist+b)))
+	}
+	r := m.rate(shipType, destType)
+	return int(math.Round(dist/r.DistancePerFuel)) + r.Extra
+}
+
+// How much fuel a ship type needs to fly a distance to a type of location
+func (m *FuelModel) Predict(shipType, destType string, dist float64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.predict(shipType, destType, dist)
+}
+
+// Record the fuel actually used by a flight, and report if it's not what we
+// predicted.
+func (m *FuelModel) Observe(shipType, destType string, dist float64, fuel int) {
+	m.mu.Lock()
+	predicted := m.predict(shipType, destType, dist)
+	k := fuelKey(shipType, destType)
+	m.samples[k] = append(m.samples[k], fuelSample{Distance: dist, Fuel: fuel})
+	if len(m.samples[k]) > maxFuelSamples {
+		m.samples[k] = m.samples[k][len(m.samples[k])-maxFuelSamples:]
+	}
+	notify := m.OnDiverge
+	m.mu.Unlock()
+
+	if predicted == fuel {
+		return
+	}
+	log.Printf("Fuel model for %s to %s at distance %.2f predicted %d, used %d",
+		shipType, destType, dist, predicted, fuel)
+	if notify != nil {
+		notify(shipType, destType, dist, predicted, fuel)
+	}
+}
+
+// Describe the table, and what we've learned for a ship type (or all if empty)
+func (m *FuelModel) String(shipType string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := []string{"Fuel table:"}
+	keys := []string{}
+	for k := range m.table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r := m.table[k]
+		if shipType != "" && r.ShipType != "" && !strings.EqualFold(r.ShipType, shipType) {
+			continue
+		}
+		res = append(res, fmt.Sprintf("  %-10s %-10s distance/fuel: %.1f, extra: %d",
+			orAny(r.ShipType), orAny(r.DestType), r.DistancePerFuel, r.Extra))
+	}
+
+	keys = []string{}
+	for k := range m.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if shipType != "" && !strings.HasPrefix(k, strings.ToUpper(shipType)+"/") {
+			continue
+		}
+		s := m.samples[k]
+		if a, b, ok := fitSamples(s); ok {
+			res = append(res, fmt.Sprintf("  %s: learned from %d flights: %.3f/distance + %.1f", k, len(s), a, b))
+		} else {
+			res = append(res, fmt.Sprintf("  %s: %d flights observed, not enough to learn from", k, len(s)))
+		}
+	}
+
+	return strings.Join(res, "\n")
+}
+
+func orAny(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+func (m *FuelModel) Save() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(m.samples)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (m *FuelModel) Load(data string) error {
+	samples := make(map[string][]fuelSample)
+	if err := json.Unmarshal([]byte(data), &samples); err != nil {
+		return fmt.Errorf("error decoding fuel samples: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = samples
+	return nil
+}
+
+func fitSamples(samples []fuelSample) (float64, float64, bool) {
+	if len(samples) < minFuelSamples {
+		return 0, 0, false
+	}
+	var xs, ys []float64
+	for _, s := range samples {
+		xs = append(xs, s.Distance)
+		ys = append(ys, float64(s.Fuel))
+	}
+	return fitLine(xs, ys)
+}
+
+// Least squares fit of y = a*x + b. Fails if there aren't at least two
+// distinct x values.
+func fitLine(xs, ys []float64) (float64, float64, bool) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, 0, false
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if math.Abs(d) < 1e-9 {
+		return 0, 0, false
+	}
+	a := (n*sxy - sx*sy) / d
+	b := (sy - a*sx) / n
+
+	return a, b, true
+}
@@ -0,0 +1,60 @@
+package spacetraders
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFuelModelDefaults(t *testing.T) {
+	m := NewFuelModel()
+	tests := []struct {
+		shipType, destType string
+		dist               float64
+		want               int
+	}{
+		{"JW-MK-I", "MOON", 15, 3},
+		{"JW-MK-I", "PLANET", 15, 5},
+		{"HM-MK-III", "MOON", 20, 3},
+		{"HM-MK-III", "PLANET", 20, 4},
+		{"GR-MK-II", "MOON", 15, 3},
+		{"GR-MK-II", "PLANET", 15, 6},
+		{"GR-MK-III", "PLANET", 15, 7},
+	}
+
+	for _, tc := range tests {
+		if got := m.Predict(tc.shipType, tc.destType, tc.dist); got != tc.want {
+			t.Errorf("%s to %s at %.1f: want %d, got %d", tc.shipType, tc.destType, tc.dist, tc.want, got)
+		}
+	}
+}
+
+func TestFuelModelLearns(t *testing.T) {
+	m := NewFuelModel()
+	var diverged int
+	m.OnDiverge = func(string, string, float64, int, int) { diverged++ }
+
+	// This ship type actually uses a fuel per 5 distance, plus 2
+	actual := func(d float64) int { return int(math.Round(d/5)) + 2 }
+	for _, d := range []float64{10, 20, 40, 60} {
+		m.Observe("XX-MK-I", "MOON", d, actual(d))
+	}
+	if diverged == 0 {
+		t.Errorf("no divergence reported")
+	}
+
+	diverged = 0
+	for _, d := range []float64{30, 50} {
+		if got := m.Predict("XX-MK-I", "MOON", d); got != actual(d) {
+			t.Errorf("distance %.0f: want %d, got %d", d, actual(d), got)
+		}
+		m.Observe("XX-MK-I", "MOON", d, actual(d))
+	}
+	if diverged != 0 {
+		t.Errorf("%d divergences reported after learning", diverged)
+	}
+
+	// Other ship types are unaffected
+	if got := m.Predict("JW-MK-I", "MOON", 30); got != 5 {
+		t.Errorf("JW-MK-I: want 5, got %d", got)
+	}
+}
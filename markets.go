@@ -0,0 +1,153 @@
+package spacetraders
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many snapshots to keep per location, and how far back to look when
+// estimating prices.
+const (
+	marketHistoryLength = 48
+	marketHistoryWindow = 24 * time.Hour
+)
+
+// A recorded view of a marketplace at a point in time
+type MarketSnapshot struct {
+	Location string    `json:"location"`
+	Time     time.Time `json:"time"`
+	Offers   []Offer   `json:"offers"`
+}
+
+// Find the offer for a good in the snapshot, if there is one
+func (m *MarketSnapshot) Offer(good string) *Offer {
+	for i, o := range m.Offers {
+		if o.Symbol == good {
+			return &m.Offers[i]
+		}
+	}
+	return nil
+}
+
+type MarketHistory struct {
+	mu        sync.Mutex
+	snapshots map[string][]MarketSnapshot
+}
+
+var markets = &MarketHistory{snapshots: make(map[string][]MarketSnapshot)}
+
+func GetMarketHistory() *MarketHistory {
+	return markets
+}
+
+// Record the offers seen at a location
+func (m *MarketHistory) Add(loc string, offers []Offer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loc = strings.ToUpper(loc)
+	m.snapshots[loc] = append(m.snapshots[loc], MarketSnapshot{
+		Location: loc,
+		Time:     time.Now(),
+		Offers:   offers,
+	})
+	if len(m.snapshots[loc]) > marketHistoryLength {
+		m.snapshots[loc] = m.snapshots[loc][len(m.snapshots[loc])-marketHistoryLength:]
+	}
+}
+
+// All the snapshots recorded for a location, oldest first
+func (m *MarketHistory) History(loc string) []MarketSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MarketSnapshot{}, m.snapshots[strings.ToUpper(loc)]...)
+}
+
+// The most recent snapshot for a location, or nil if we've never seen it
+func (m *MarketHistory) Latest(loc string) *MarketSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.snapshots[strings.ToUpper(loc)]
+	if len(h) == 0 {
+		return nil
+	}
+	s := h[len(h)-1]
+	return &s
+}
+
+// Locations we have market data for
+func (m *MarketHistory) Locations() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []string{}
+	for l := range m.snapshots {
+		res = append(res, l)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Average the prices seen at a location recently into a single list of
+// offers. Quantities are taken from the latest snapshot.
+func (m *MarketHistory) Expected(loc string) []Offer {
+	h := m.History(loc)
+	if len(h) == 0 {
+		return nil
+	}
+	latest := h[len(h)-1]
+	cutoff := latest.Time.Add(-marketHistoryWindow)
+
+	type sum struct {
+		n, buy, sell, price int
+	}
+	sums := make(map[string]*sum)
+	for _, s := range h {
+		if s.Time.Before(cutoff) {
+			continue
+		}
+		for _, o := range s.Offers {
+			if _, ok := sums[o.Symbol]; !ok {
+				sums[o.Symbol] = &sum{}
+			}
+			sums[o.Symbol].n++
+			sums[o.Symbol].buy += o.PurchasePricePerUnit
+			sums[o.Symbol].sell += o.SellPricePerUnit
+			sums[o.Symbol].price += o.PricePerUnit
+		}
+	}
+
+	res := []Offer{}
+	for _, o := range latest.Offers {
+		s := sums[o.Symbol]
+		o.PurchasePricePerUnit = s.buy / s.n
+		o.SellPricePerUnit = s.sell / s.n
+		o.PricePerUnit = s.price / s.n
+		res = append(res, o)
+	}
+
+	return res
+}
+
+func (m *MarketHistory) Save() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(m.snapshots)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (m *MarketHistory) Load(data string) error {
+	snapshots := make(map[string][]MarketSnapshot)
+	if err := json.Unmarshal([]byte(data), &snapshots); err != nil {
+		return fmt.Errorf("error decoding market history: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = snapshots
+	return nil
+}
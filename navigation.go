@@ -0,0 +1,142 @@
+package spacetraders
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A single hop of a simulated journey
+type Hop struct {
+	From    *Location
+	To      *Location
+	Fuel    int
+	Refuel  int
+	Departs time.Time
+	Arrives time.Time
+	Problem string
+}
+
+func (h *Hop) String() string {
+	res := fmt.Sprintf("%s -> %s: fuel: %d", h.From.Symbol, h.To.Symbol, h.Fuel)
+	if h.Refuel > 0 {
+		res += fmt.Sprintf(" (buy %d at %s)", h.Refuel, h.From.Symbol)
+	}
+	res += fmt.Sprintf(", departs %s, arrives %s",
+		h.Departs.Local().Format("15:04:05"), h.Arrives.Local().Format("15:04:05"))
+	if h.Problem != "" {
+		res += " !! " + h.Problem
+	}
+	return res
+}
+
+// Simulate flying the ship from start through each of the destinations,
+// starting with the given fuel, and buying just enough fuel for each hop at
+// locations where sellsFuel is true. Nothing is sent to the API. Hops that
+// can't be flown have their Problem set.
+func (s *Ship) PlanHops(start *Location, fuel int, dests []*Location, sellsFuel func(string) bool, now time.Time) []Hop {
+	var hops []Hop
+	// Room for fuel is whatever isn't taken by other cargo
+	capacity := s.SpaceAvailable + fuel
+	cur := start
+	for _, dest := range dests {
+		h := Hop{
+			From:    cur,
+			To:      dest,
+			Fuel:    s.FuelNeeded(cur, dest),
+			Departs: now,
+		}
+		h.Arrives = now.Add(s.FlightTime(cur, dest))
+
+		if fuel < h.Fuel {
+			switch {
+			case h.Fuel > capacity:
+				h.Problem = fmt.Sprintf("needs %d fuel, only room for %d", h.Fuel, capacity)
+			case !sellsFuel(cur.Symbol):
+				h.Problem = fmt.Sprintf("needs %d more fuel, none known for sale at %s", h.Fuel-fuel, cur.Symbol)
+			default:
+				h.Refuel = h.Fuel - fuel
+				fuel = h.Fuel
+			}
+		}
+
+		fuel -= h.Fuel
+		if fuel < 0 {
+			fuel = 0
+		}
+		hops = append(hops, h)
+		cur = dest
+		now = h.Arrives
+	}
+
+	return hops
+}
+
+// Describe a list of hops, one per line
+func Itinerary(hops []Hop) string {
+	res := []string{}
+	for i, h := range hops {
+		res = append(res, fmt.Sprintf("%d: %s", i+1, h.String()))
+	}
+	return strings.Join(res, "\n")
+}
+
+// Find the quickest way to fly the ship from start to dest, stopping to
+// refuel only at locations where sellsFuel is true. Returns the stops after
+// start, ending with dest.
+func (s *Ship) FindPath(start, dest *Location, locs []*Location, fuel int, sellsFuel func(string) bool) ([]*Location, error) {
+	capacity := s.SpaceAvailable + fuel
+	// How much fuel the ship can have when leaving a location
+	maxFuel := func(l *Location) int {
+		if l.Symbol == start.Symbol && !sellsFuel(l.Symbol) {
+			return fuel
+		}
+		return capacity
+	}
+
+	nodes := map[string]*Location{start.Symbol: start, dest.Symbol: dest}
+	for _, l := range locs {
+		if l.Symbol == start.Symbol || l.Symbol == dest.Symbol || sellsFuel(l.Symbol) {
+			nodes[l.Symbol] = l
+		}
+	}
+
+	// Dijkstra, by flight time
+	best := map[string]time.Duration{start.Symbol: 0}
+	prev := make(map[string]string)
+	done := make(map[string]bool)
+	for {
+		cur := ""
+		for n, d := range best {
+			if !done[n] && (cur == "" || d < best[cur]) {
+				cur = n
+			}
+		}
+		if cur == "" {
+			return nil, fmt.Errorf("no route for %s from %s to %s with refuelling stops", s.Type, start.Symbol, dest.Symbol)
+		}
+		if cur == dest.Symbol {
+			break
+		}
+		done[cur] = true
+
+		from := nodes[cur]
+		for n, to := range nodes {
+			if done[n] || s.FuelNeeded(from, to) > maxFuel(from) {
+				continue
+			}
+			d := best[cur] + s.FlightTime(from, to)
+			if old, ok := best[n]; !ok || d < old {
+				best[n] = d
+				prev[n] = cur
+			}
+		}
+	}
+
+	var path []*Location
+	for n := dest.Symbol; n != start.Symbol; n = prev[n] {
+		path = append([]*Location{nodes[n]}, path...)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,145 @@
+package spacetraders
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Moons in a line. With the default models, a TEST ship uses 1 fuel per 7.5
+// distance plus 1, and takes 2 seconds per distance plus 30.
+var (
+	locA = &Location{Symbol: "X1-A", Type: "MOON", X: 0}
+	locB = &Location{Symbol: "X1-B", Type: "MOON", X: 15}
+	locC = &Location{Symbol: "X1-C", Type: "MOON", X: 30}
+	locD = &Location{Symbol: "X1-D", Type: "MOON", X: 60}
+	locE = &Location{Symbol: "X1-E", Type: "MOON", Y: 300}
+)
+
+func sells(symbols ...string) func(string) bool {
+	return func(s string) bool {
+		for _, sym := range symbols {
+			if s == sym {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestPlanHops(t *testing.T) {
+	ship := &Ship{Type: "TEST", Speed: 1, SpaceAvailable: 3}
+	now := time.Date(2021, 10, 9, 9, 0, 0, 0, time.Local)
+	type hop struct {
+		From, To     string
+		Fuel, Refuel int
+		Took         time.Duration
+		Problem      string
+	}
+	tests := []struct {
+		desc  string
+		dests []*Location
+		sells func(string) bool
+		want  []hop
+	}{
+		{
+			desc:  "multi-hop with refuelling",
+			dests: []*Location{locB, locC, locD},
+			sells: sells("X1-B", "X1-C"),
+			want: []hop{
+				{From: "X1-A", To: "X1-B", Fuel: 3, Took: time.Minute},
+				{From: "X1-B", To: "X1-C", Fuel: 3, Refuel: 3, Took: time.Minute},
+				{From: "X1-C", To: "X1-D", Fuel: 5, Refuel: 5, Took: 90 * time.Second},
+			},
+		},
+		{
+			desc:  "no fuel for sale",
+			dests: []*Location{locB, locC},
+			sells: sells(),
+			want: []hop{
+				{From: "X1-A", To: "X1-B", Fuel: 3, Took: time.Minute},
+				{From: "X1-B", To: "X1-C", Fuel: 3, Took: time.Minute, Problem: "needs 3 more fuel, none known for sale at X1-B"},
+			},
+		},
+		{
+			desc:  "too far",
+			dests: []*Location{locD},
+			sells: sells("X1-A"),
+			want: []hop{
+				{From: "X1-A", To: "X1-D", Fuel: 9, Took: 150 * time.Second, Problem: "needs 9 fuel, only room for 6"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []hop
+			for _, h := range ship.PlanHops(locA, 3, tc.dests, tc.sells, now) {
+				got = append(got, hop{
+					From: h.From.Symbol, To: h.To.Symbol,
+					Fuel: h.Fuel, Refuel: h.Refuel,
+					Took:    h.Arrives.Sub(h.Departs),
+					Problem: h.Problem,
+				})
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("bad hops: -want +got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItinerary(t *testing.T) {
+	ship := &Ship{Type: "TEST", Speed: 1, SpaceAvailable: 3}
+	now := time.Date(2021, 10, 9, 9, 0, 0, 0, time.Local)
+	hops := ship.PlanHops(locA, 3, []*Location{locB, locC}, sells("X1-B"), now)
+	want := []string{
+		"1: X1-A -> X1-B: fuel: 3, departs 09:00:00, arrives 09:01:00",
+		"2: X1-B -> X1-C: fuel: 3 (buy 3 at X1-B), departs 09:01:00, arrives 09:02:00",
+	}
+	if diff := cmp.Diff(want, strings.Split(Itinerary(hops), "\n")); diff != "" {
+		t.Errorf("bad itinerary: -want +got\n%s", diff)
+	}
+}
+
+func TestFindPath(t *testing.T) {
+	ship := &Ship{Type: "TEST", Speed: 1, SpaceAvailable: 3}
+	locs := []*Location{locA, locB, locC, locD, locE}
+	tests := []struct {
+		desc    string
+		dest    *Location
+		sells   func(string) bool
+		want    []string
+		wantErr bool
+	}{
+		{desc: "direct", dest: locB, sells: sells(), want: []string{"X1-B"}},
+		{desc: "refuelling stops", dest: locD, sells: sells("X1-B", "X1-C"), want: []string{"X1-B", "X1-C", "X1-D"}},
+		{desc: "refuel at the start", dest: locD, sells: sells("X1-A", "X1-B", "X1-C"), want: []string{"X1-C", "X1-D"}},
+		{desc: "no fuel on the way", dest: locD, sells: sells("X1-B"), wantErr: true},
+		{desc: "too far", dest: locE, sells: sells("X1-A", "X1-B", "X1-C", "X1-D"), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			path, err := ship.FindPath(locA, tc.dest, locs, 3, tc.sells)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got %v", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, l := range path {
+				got = append(got, l.Symbol)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("bad path: -want +got\n%s", diff)
+			}
+		})
+	}
+}
@@ -10,17 +10,22 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 var useDebug = flag.Bool("debug", false, "Print out all debug statements")
 
 type Client struct {
+	mu          sync.Mutex // protects flightDests, locations and loansDue
+	shipsMu     sync.Mutex // serializes changes to the cached ships and credits
 	httpClient  *http.Client
 	username    string
 	token       string
 	server      string
 	flightDests map[string]string
+	locations   map[string]Location
+	loansDue    map[string]bool
 	cache       *Cache
 }
 
@@ -50,6 +55,8 @@ func New() *Client {
 		server:      "https://api.spacetraders.io",
 		cache:       ca,
 		flightDests: make(map[string]string),
+		locations:   make(map[string]Location),
+		loansDue:    make(map[string]bool),
 	}
 	for _, k := range []CacheKey{LOCATIONS, SYSTEMS} {
 		ca.RegisterUpdate(k, func() error {
@@ -83,8 +90,16 @@ func (c *Client) Load(path string) error {
 	return nil
 }
 
+// Use a different API server, e.g. a fake one for tests
+func (c *Client) SetServer(server string) {
+	c.server = server
+}
+
 // Low level REST functions
-var calls []time.Time
+var (
+	callsMu sync.Mutex
+	calls   []time.Time
+)
 
 const (
 	burstCount = 8
@@ -92,7 +107,11 @@ const (
 	callRate   = 2
 )
 
+// Wait until another call can be made. Safe to call from several goroutines,
+// which will take turns.
 func rateLimit() {
+	callsMu.Lock()
+	defer callsMu.Unlock()
 	defer func() {
 		calls = append(calls, time.Now())
 	}()
@@ -229,6 +248,9 @@ func (c *Client) DoPost(method, base string, args map[string]string) (string, er
 	resp, err := backoff(func() (*http.Response, error) {
 		return c.httpClient.Do(req)
 	})
+	if resp == nil {
+		return "", fmt.Errorf("error in POST %q: %v", base, err)
+	}
 
 	defer resp.Body.Close()
 	resBody, _ := ioutil.ReadAll(resp.Body)
@@ -265,6 +287,9 @@ func (c *Client) Get(base string, args map[string]string) (string, error) {
 	resp, err := backoff(func() (*http.Response, error) {
 		return http.Get(uri)
 	})
+	if resp == nil {
+		return "", fmt.Errorf("error in GET %q: %v", base, err)
+	}
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		defer resp.Body.Close()
 		body, _ := ioutil.ReadAll(resp.Body)
@@ -329,7 +354,9 @@ func (c *Client) Account() (*User, error) {
 	}
 
 	u := &ar.User
+	c.setCredits(u.Credits)
 	c.cache.StoreObjs(USEROBJ, []interface{}{u})
+	c.checkLoans(u.Loans)
 
 	return u, nil
 }
@@ -360,6 +387,9 @@ func (c *Client) TakeLoan(name string) (*Loan, error) {
 	}
 	tlr.Loan.ShortID = makeShort(LOANS, tlr.Loan.ID)
 	c.cache.Add(LOANS, tlr.Loan.ID)
+	loan := tlr.Loan
+	events.Publish(Event{Type: LoanTaken, Loan: &loan})
+	c.setCredits(tlr.Credits)
 
 	return &tlr.Loan, nil
 }
@@ -381,6 +411,7 @@ func (c *Client) MyLoans() ([]Loan, error) {
 		shorts = append(shorts, sid)
 	}
 	c.cache.Store(LOANS, ids, shorts)
+	c.checkLoans(mlr.Loans)
 
 	return mlr.Loans, nil
 }
@@ -393,6 +424,7 @@ func (c *Client) PayLoan(loanID string) error {
 	if err := c.useAPI(put, fmt.Sprintf("/my/loans/%s", loanID), nil, plr); err != nil {
 		return err
 	}
+	c.setCredits(plr.Credits)
 
 	return nil
 }
@@ -408,13 +440,17 @@ func (c *Client) ListSystems() ([]System, error) {
 
 	systems := []string{}
 	locations := []string{}
-	for _, s := range sr.Systems {
+	c.mu.Lock()
+	for i, s := range sr.Systems {
 		systems = append(systems, s.Symbol)
-		for _, l := range s.Locations {
+		for j := range s.Locations {
+			l := &sr.Systems[i].Locations[j]
 			l.SystemSymbol = s.Symbol
 			locations = append(locations, l.Symbol)
+			c.locations[l.Symbol] = *l
 		}
 	}
+	c.mu.Unlock()
 	c.cache.Store(SYSTEMS, systems, nil)
 	c.cache.Store(LOCATIONS, locations, nil)
 
@@ -433,8 +469,8 @@ func (c *Client) ListLocations(system string, kind string) ([]Location, error) {
 		return nil, err
 	}
 
-	for _, l := range lr.Locations {
-		l.SystemSymbol = system
+	for i := range lr.Locations {
+		lr.Locations[i].SystemSymbol = system
 	}
 
 	return lr.Locations, nil
@@ -465,6 +501,7 @@ func (c *Client) BuyShip(location, kind string) (*Ship, error) {
 	}
 	bsr.Ship.ShortID = makeShort(SHIPS, bsr.Ship.ID)
 	c.cache.Add(SHIPS, bsr.Ship.ID)
+	c.setCredits(bsr.Credits)
 
 	return &bsr.Ship, nil
 }
@@ -477,6 +514,13 @@ func (c *Client) MyShips() ([]Ship, error) {
 		return nil, err
 	}
 
+	c.shipsMu.Lock()
+	defer c.shipsMu.Unlock()
+	old := make(map[string]*Ship)
+	for _, o := range c.cache.RestoreObjs(SHIPOBJ) {
+		old[o.(*Ship).ID] = o.(*Ship)
+	}
+
 	ids := []string{}
 	shorts := []string{}
 	locs := []string{}
@@ -500,6 +544,11 @@ func (c *Client) MyShips() ([]Ship, error) {
 	c.cache.Store(MYLOCATIONS, locs, nil)
 	c.cache.Store(FLIGHTS, flights, nil)
 	c.cache.StoreObjs(SHIPOBJ, so)
+	for i, s := range msr.Ships {
+		if o, ok := old[s.ID]; ok {
+			publishShipEvents(shipEvents(o, &msr.Ships[i]))
+		}
+	}
 
 	return msr.Ships, nil
 }
@@ -520,10 +569,48 @@ func (c *Client) CreateFlight(shipID, destination string) (*FlightPlan, error) {
 	fp.ShortID = makeShort(FLIGHTS, fp.ID)
 	fp.ShortShipID = makeShort(SHIPS, fp.ShipID)
 	c.cache.Add(FLIGHTS, fp.ID)
+	c.observeFlight(&fp)
+	c.flightCreated(fp)
+	arrivals.track(c, fp)
 
 	return &fp, nil
 }
 
+// Feed the fuel used and time taken by a new flight back into the models
+func (c *Client) observeFlight(fp *FlightPlan) {
+	var ship *Ship
+	for _, o := range c.cache.RestoreObjs(SHIPOBJ) {
+		if s := o.(*Ship); s.ID == fp.ShipID {
+			ship = s
+		}
+	}
+	if ship == nil {
+		log.Printf("Unknown ship %s, not updating fuel model", fp.ShipID)
+		return
+	}
+
+	if _, ok := c.location(fp.Destination); !ok {
+		if _, err := c.ListSystems(); err != nil {
+			log.Printf("Can't load locations for fuel model: %v", err)
+			return
+		}
+	}
+	dest, ok := c.location(fp.Destination)
+	if !ok {
+		log.Printf("Unknown destination %s, not updating fuel model", fp.Destination)
+		return
+	}
+	dist := float64(fp.Distance)
+	if src, ok := c.location(fp.Departure); ok {
+		dist = src.Distance(&dest)
+	}
+
+	fuelModel.Observe(ship.Type, dest.Type, dist, fp.FuelConsumed)
+	if !fp.CreatedAt.IsZero() && fp.ArrivesAt.After(fp.CreatedAt) {
+		travelModel.Observe(ship.Speed, dist, fp.ArrivesAt.Sub(fp.CreatedAt))
+	}
+}
+
 // ##ENDPOINT Show flight plans - `/my/flight-plans/FLIGHTID`
 func (c *Client) ShowFlight(flightID string) (*FlightPlan, error) {
 	flightID = makeLong(flightID)
@@ -535,20 +622,53 @@ func (c *Client) ShowFlight(flightID string) (*FlightPlan, error) {
 	fp := fpr.FlightPlan
 	fp.ShortID = makeShort(FLIGHTS, fp.ID)
 	fp.ShortShipID = makeShort(SHIPS, fp.ShipID)
+	arrivals.track(c, fp)
 
 	return &fp, nil
 }
 
+// A location seen in ListSystems
+func (c *Client) location(symbol string) (Location, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locations[symbol]
+	return l, ok
+}
+
+// A location seen in ListSystems, without calling the API
+func (c *Client) KnownLocation(symbol string) (*Location, bool) {
+	l, ok := c.location(strings.ToUpper(symbol))
+	if !ok {
+		return nil, false
+	}
+	return &l, true
+}
+
+// The destination of a ship's flight. Flights that aren't watched yet, e.g.
+// ships that were already in flight on startup, are looked up and watched.
 func (c *Client) getFlightDest(flightID string) string {
-	if d, ok := c.flightDests[flightID]; ok {
+	if fp, ok := arrivals.Flight(flightID); ok {
+		return fp.Destination
+	}
+	c.mu.Lock()
+	d, ok := c.flightDests[flightID]
+	c.mu.Unlock()
+	if ok && arrivals.announced(flightID) {
 		return d
 	}
 	fp, err := c.ShowFlight(flightID)
 	if err != nil {
 		log.Printf("Error looking up %s: %v", flightID, err)
+		if ok {
+			return d
+		}
 		return "Unknown"
 	}
+	// The ship is still on it, so watch it even if it's overdue
+	arrivals.watch(c, *fp)
+	c.mu.Lock()
 	c.flightDests[flightID] = fp.Destination
+	c.mu.Unlock()
 
 	return fp.Destination
 }
@@ -571,6 +691,7 @@ func (c *Client) BuyCargo(shipID, good string, qty int) (*Order, error) {
 
 	// Didn't error, must be real
 	c.cache.Extend(CARGO, []string{good}, nil)
+	c.orderFilled(br.Order, br.Ship, br.Credits, true)
 
 	return &br.Order, nil
 }
@@ -592,6 +713,7 @@ func (c *Client) SellCargo(shipID, good string, qty int) (*Order, error) {
 
 	// Didn't error, must be real
 	c.cache.Extend(CARGO, []string{good}, nil)
+	c.orderFilled(sr.Order, sr.Ship, sr.Credits, false)
 
 	return &sr.Order, nil
 }
@@ -608,6 +730,7 @@ func (c *Client) Marketplace(loc string) ([]Offer, error) {
 		cargoType = append(cargoType, o.Symbol)
 	}
 	c.cache.Extend(CARGO, cargoType, nil)
+	markets.Add(loc, mr.Offers)
 
 	return mr.Offers, nil
 }
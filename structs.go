@@ -230,29 +230,15 @@ func (s *Ship) Listing() string {
 	return strings.Join(res, "\n")
 }
 
+// How much fuel the ship needs to fly between two locations, according to
+// the fuel model
 func (s *Ship) FuelNeeded(src, dest *Location) int {
-	// From https://discord.com/channels/792864705139048469/852291054957887498/852292011024187442
-	dist := math.Hypot(float64(src.X-dest.X), float64(src.Y-dest.Y))
-	var fuel int
-	if s.Type == "HM-MK-III" {
-		fuel = int(math.Round(dist/10) + 1)
-	} else {
-		fuel = int(math.Round(dist/7.5) + 1)
-	}
-	if dest.Type == "PLANET" {
-		switch s.Type {
-		case "HM-MK-III":
-			fuel += 1
-		case "GR-MK-II":
-			fuel += 3
-		case "GR-MK-III":
-			fuel += 4
-		default:
-			fuel += 2
-		}
-	}
+	return fuelModel.Predict(s.Type, dest.Type, src.Distance(dest))
+}
 
-	return fuel
+// Estimate how long a flight will take, according to the travel model
+func (s *Ship) FlightTime(src, dest *Location) time.Duration {
+	return travelModel.Predict(s.Speed, src.Distance(dest))
 }
 
 type Cargo struct {
@@ -0,0 +1,52 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Saturday
+	start := time.Date(2021, 10, 9, 12, 30, 20, 0, time.UTC)
+	tests := []struct {
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{expr: "5 * * * *", want: time.Date(2021, 10, 9, 13, 5, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2021, 10, 9, 12, 45, 0, 0, time.UTC)},
+		{expr: "0,31 12 * * *", want: time.Date(2021, 10, 9, 12, 31, 0, 0, time.UTC)},
+		{expr: "0 9-17 * * 1-5", want: time.Date(2021, 10, 11, 9, 0, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2021, 10, 10, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 1 *", want: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "@daily", want: time.Date(2021, 10, 10, 0, 0, 0, 0, time.UTC)},
+		{expr: "30 12 * * *", want: time.Date(2021, 10, 10, 12, 30, 0, 0, time.UTC)},
+		{expr: "* * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "0 0 31 2 *"},
+	}
+
+	for _, tc := range tests {
+		spec, err := parseCron(tc.expr)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: wanted error", tc.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.expr, err)
+			continue
+		}
+		got, ok := spec.next(start)
+		if ok != !tc.want.IsZero() {
+			t.Errorf("%q: want found=%v, got %v", tc.expr, !tc.want.IsZero(), ok)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%q: want %s, got %s", tc.expr, tc.want, got)
+		}
+	}
+}
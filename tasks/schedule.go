@@ -0,0 +1,223 @@
+package tasks
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a task whose condition isn't met, and doesn't otherwise repeat,
+// checks again
+const conditionRecheck = time.Minute
+
+// When a task runs again after it's first due. The zero value runs once.
+type Schedule struct {
+	// Run again this long after each run
+	Every time.Duration `json:"every,omitempty"`
+	// Run at the times matching a cron expression, instead of Every
+	Cron string `json:"cron,omitempty"`
+	// Add up to this much random delay to each run
+	Jitter time.Duration `json:"jitter,omitempty"`
+	// Don't run after this time
+	Until time.Time `json:"until"`
+	// Stop after this many runs
+	Max int `json:"max,omitempty"`
+	// Only run when this condition is met, e.g. credits>100000. If it isn't,
+	// the run is skipped.
+	When string `json:"when,omitempty"`
+	// Give up on a run after this long, instead of DefaultTimeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// Check that the cron expression and condition can be parsed
+func (s Schedule) Validate() error {
+	if s.Every < 0 || s.Jitter < 0 || s.Max < 0 || s.Timeout < 0 {
+		return fmt.Errorf("durations and max runs can't be negative")
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return err
+		}
+	}
+	if s.When != "" {
+		if _, err := parseCondition(s.When); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Schedule) String() string {
+	var res []string
+	if s.Cron != "" {
+		res = append(res, fmt.Sprintf("cron %q", s.Cron))
+	} else if s.Every > 0 {
+		res = append(res, fmt.Sprintf("every %s", s.Every))
+	}
+	if s.Jitter > 0 {
+		res = append(res, fmt.Sprintf("jitter %s", s.Jitter))
+	}
+	if !s.Until.IsZero() {
+		res = append(res, fmt.Sprintf("until %s", s.Until.Format("2006-01-02 15:04")))
+	}
+	if s.Max > 0 {
+		res = append(res, fmt.Sprintf("max %d runs", s.Max))
+	}
+	if s.When != "" {
+		res = append(res, fmt.Sprintf("when %s", s.When))
+	}
+	if s.Timeout > 0 {
+		res = append(res, fmt.Sprintf("timeout %s", s.Timeout))
+	}
+	return strings.Join(res, ", ")
+}
+
+func (s Schedule) expired(now time.Time) bool {
+	return !s.Until.IsZero() && now.After(s.Until)
+}
+
+// The first time a cron task is due after now, or now for other tasks
+func (s Schedule) first(now time.Time) time.Time {
+	if s.Cron == "" {
+		return now
+	}
+	if next, ok := s.next(now, 0); ok {
+		return next
+	}
+	return now
+}
+
+// When to run next, after having run a number of times. False if the task
+// shouldn't run again.
+func (s Schedule) next(now time.Time, runs int) (time.Time, bool) {
+	if s.Max > 0 && runs >= s.Max {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	switch {
+	case s.Cron != "":
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		var ok bool
+		if next, ok = spec.next(now); !ok {
+			return time.Time{}, false
+		}
+	case s.Every > 0:
+		next = now.Add(s.Every)
+	default:
+		return time.Time{}, false
+	}
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+	if s.expired(next) {
+		return time.Time{}, false
+	}
+
+	return next, true
+}
+
+// Whether the condition, if any, is met
+func (s Schedule) ready() (bool, error) {
+	if s.When == "" {
+		return true, nil
+	}
+	cond, err := parseCondition(s.When)
+	if err != nil {
+		return false, err
+	}
+	return cond.met()
+}
+
+var (
+	valuesMu sync.Mutex
+	values   = make(map[string]func() (int, error))
+)
+
+// Make a value available to task conditions, e.g. credits. f should use
+// cached state rather than calling the API.
+func RegisterValue(name string, f func() (int, error)) error {
+	valuesMu.Lock()
+	defer valuesMu.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := values[name]; ok {
+		return fmt.Errorf("already have a value %q", name)
+	}
+	values[name] = f
+	return nil
+}
+
+// The names of the values conditions can use
+func Values() []string {
+	valuesMu.Lock()
+	defer valuesMu.Unlock()
+	var res []string
+	for n := range values {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// A comparison of a registered value to a number, e.g. credits>=100000
+type condition struct {
+	value  string
+	op     string
+	target int
+}
+
+// Longer operators first, so >= isn't read as >
+var condOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+func parseCondition(s string) (*condition, error) {
+	for _, op := range condOps {
+		i := strings.Index(s, op)
+		if i < 0 {
+			continue
+		}
+		name := strings.ToLower(s[:i])
+		valuesMu.Lock()
+		_, ok := values[name]
+		valuesMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown value %q in condition %q", s[:i], s)
+		}
+		n, err := strconv.Atoi(s[i+len(op):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in condition %q", s)
+		}
+		return &condition{value: name, op: op, target: n}, nil
+	}
+	return nil, fmt.Errorf("condition %q must look like value>number", s)
+}
+
+func (c *condition) met() (bool, error) {
+	valuesMu.Lock()
+	f := values[c.value]
+	valuesMu.Unlock()
+	v, err := f()
+	if err != nil {
+		return false, fmt.Errorf("can't get %s: %v", c.value, err)
+	}
+
+	switch c.op {
+	case ">=":
+		return v >= c.target, nil
+	case "<=":
+		return v <= c.target, nil
+	case "!=":
+		return v != c.target, nil
+	case ">":
+		return v > c.target, nil
+	case "<":
+		return v < c.target, nil
+	}
+	return v == c.target, nil
+}
@@ -1,8 +1,13 @@
 package tasks
 
 import (
+	"container/heap"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,27 +15,201 @@ import (
 )
 
 var (
-	tq *taskQueue
+	tq    *taskQueue
+	kinds = make(map[string]Kind)
 )
 
+const (
+	// How many tasks can run at once
+	workers = 4
+	// How long a task can run before it's considered failed, unless its
+	// schedule says otherwise
+	DefaultTimeout = 10 * time.Minute
+)
+
+// Builds the function for a task from its arguments. Tasks added with a kind
+// only need the kind and arguments to be saved, and are re-created on load.
+type Kind func(args []string) (func(c *spacetraders.Client) error, error)
+
+func buildKind(kind string, args []string) (func(c *spacetraders.Client) error, error) {
+	k, ok := kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown task kind %q", kind)
+	}
+	f, err := k(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s task: %v", kind, err)
+	}
+	return f, nil
+}
+
+func RegisterKind(name string, k Kind) error {
+	if _, ok := kinds[name]; ok {
+		return fmt.Errorf("already have a task kind %q", name)
+	}
+	kinds[name] = k
+	return nil
+}
+
 type task struct {
-	when   time.Time
-	repeat time.Duration
-	f      func(c *spacetraders.Client) error
-	msg    string
+	key   string
+	when  time.Time
+	sched Schedule
+	f     func(c *spacetraders.Client) error
+	msg   string
+	index int
+	kind  string
+	args  []string
+
+	runs    int
+	running bool
+	// When Reschedule asked for it to run next, if it was running at the time
+	rescheduled time.Time
+	lastRun     time.Time
+	lastErr     error
+	// How long runs took, including ones run by hand
+	calls     int
+	lastTook  time.Duration
+	maxTook   time.Duration
+	totalTook time.Duration
+}
+
+// A snapshot of a task, for display
+type TaskInfo struct {
+	Key      string
+	Msg      string
+	When     time.Time
+	Schedule Schedule
+	Runs     int
+	Kind     string
+	Args     []string
+	Running  bool
+	LastRun  time.Time
+	LastErr  string
+	LastTook time.Duration
+	MaxTook  time.Duration
+	AvgTook  time.Duration
+}
+
+func (i TaskInfo) String() string {
+	res := fmt.Sprintf("%s: next run %s", i.Key, i.When.Format("15:04:05"))
+	if due := time.Until(i.When); due > 0 {
+		res += fmt.Sprintf(" (in %s)", due.Truncate(time.Second))
+	}
+	if s := i.Schedule.String(); s != "" {
+		res += ", " + s
+	}
+	if i.Runs > 0 {
+		res += fmt.Sprintf(", %d runs", i.Runs)
+	}
+	if i.Msg != "" {
+		res += fmt.Sprintf(", message: %q", i.Msg)
+	}
+	if i.Kind != "" {
+		res += fmt.Sprintf(", %s %q", i.Kind, i.Args)
+	}
+	switch {
+	case i.Running:
+		res += ", running"
+	case i.LastRun.IsZero():
+		res += ", never run"
+	case i.LastErr != "":
+		res += fmt.Sprintf(", failed at %s: %s", i.LastRun.Format("15:04:05"), i.LastErr)
+	default:
+		res += fmt.Sprintf(", ok at %s", i.LastRun.Format("15:04:05"))
+	}
+	if !i.LastRun.IsZero() {
+		res += fmt.Sprintf(", took %s (avg %s, max %s)",
+			i.LastTook.Round(time.Millisecond), i.AvgTook.Round(time.Millisecond), i.MaxTook.Round(time.Millisecond))
+	}
+	return res
+}
+
+// Counts for the whole queue
+type Stats struct {
+	Workers  int
+	Waiting  int
+	Running  int
+	Runs     int
+	Failed   int
+	Panics   int
+	TimedOut int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d workers, %d running, %d waiting; %d runs, %d failed (%d panicked, %d timed out)",
+		s.Workers, s.Running, s.Waiting, s.Runs, s.Failed, s.Panics, s.TimedOut)
+}
+
+var errRunning = errors.New("already running")
+
+// What happened when a task ran, to be returned by ProcessTasks
+type result struct {
+	msg string
+	err error
+}
+
+// Tasks ordered by when they're due
+type taskHeap []*task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
 }
 
 type taskQueue struct {
-	mu       sync.Mutex
-	tasks    map[string]*task
-	c        *spacetraders.Client
-	nextTime time.Time
+	mu    sync.Mutex
+	queue taskHeap
+	tasks map[string]*task
+	c     *spacetraders.Client
+	// Closed and replaced whenever the queue changes, to wake up Next
+	changed chan struct{}
+	// Fires when the next task is due, reset by Next
+	timer *time.Timer
+
+	// Due tasks waiting for a worker, in order
+	ready   []*task
+	wake    *sync.Cond
+	pending sync.WaitGroup
+	results []result
+	stats   Stats
 }
 
 func init() {
-	tq = &taskQueue{
-		tasks: make(map[string]*task),
+	tq = newTaskQueue()
+}
+
+func newTaskQueue() *taskQueue {
+	tq := &taskQueue{
+		tasks:   make(map[string]*task),
+		changed: make(chan struct{}),
+		timer:   time.NewTimer(time.Hour),
 	}
+	tq.timer.Stop()
+	tq.wake = sync.NewCond(&tq.mu)
+	tq.stats.Workers = workers
+	for i := 0; i < workers; i++ {
+		go tq.worker()
+	}
+	return tq
 }
 
 func GetTaskQueue() *taskQueue {
@@ -45,106 +224,592 @@ func RunAt(key string, when time.Time) error {
 	return tq.RunAt(key, when)
 }
 
+func Cancel(key string) error {
+	return tq.Cancel(key)
+}
+
 func (tq *taskQueue) SetClient(c *spacetraders.Client) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
 	tq.c = c
 }
 
+// Let anything waiting on Next know the queue changed. tq.mu must be held.
+func (tq *taskQueue) notify() {
+	close(tq.changed)
+	tq.changed = make(chan struct{})
+}
+
+// Hand the tasks that are due to the workers, and return the messages and
+// errors from tasks that finished since the last call. Tasks are run without
+// holding the lock, so they can add or cancel tasks, including themselves.
 func (tq *taskQueue) ProcessTasks() ([]string, error) {
-	if tq.nextTime.After(time.Now()) {
-		return nil, nil
+	now := time.Now()
+	var due []*task
+	// The schedules as they were when the tasks were due, as they can be
+	// changed while the conditions are checked
+	var scheds []Schedule
+	tq.mu.Lock()
+	for len(tq.queue) > 0 && !tq.queue[0].when.After(now) {
+		t := heap.Pop(&tq.queue).(*task)
+		due = append(due, t)
+		scheds = append(scheds, t.sched)
+	}
+	results := tq.results
+	tq.results = nil
+	tq.mu.Unlock()
+
+	for i, t := range due {
+		sched := scheds[i]
+		if sched.expired(now) {
+			log.Printf("task %q expired at %s", t.key, sched.Until)
+			tq.requeue(t, false)
+			continue
+		}
+		if ok, condErr := sched.ready(); !ok {
+			if condErr != nil {
+				log.Printf("can't check condition for task %q: %v", t.key, condErr)
+			}
+			log.Printf("skipping task %q, %s isn't met", t.key, sched.When)
+			tq.requeue(t, false)
+			continue
+		}
+
+		tq.mu.Lock()
+		if t.running {
+			tq.mu.Unlock()
+			log.Printf("skipping task %q, it's still running", t.key)
+			tq.requeue(t, false)
+			continue
+		}
+		tq.ready = append(tq.ready, t)
+		tq.pending.Add(1)
+		tq.wake.Signal()
+		tq.mu.Unlock()
 	}
 
 	var msgs []string
 	var errs []error
 	var err error
-	for k, t := range tq.tasks {
-		if t.when.Before(time.Now()) {
-			log.Printf("executing task %q", k)
-			msg, err := tq.Run(k)
-			if msg != "" {
-				msgs = append(msgs, msg)
-			}
-			if err != nil {
-				errs = append(errs, err)
-			}
-			if t.repeat == 0 {
-				delete(tq.tasks, k)
-				continue
-			}
+	for _, r := range results {
+		if r.msg != "" {
+			msgs = append(msgs, r.msg)
+		}
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("%d errors while processing background tasks: %v", len(errs), errs)
+	}
+
+	return msgs, err
+}
+
+// Wait until all the tasks handed to workers have finished or timed out
+func (tq *taskQueue) Wait() {
+	tq.pending.Wait()
+}
+
+func (tq *taskQueue) worker() {
+	for {
+		tq.mu.Lock()
+		for len(tq.ready) == 0 {
+			tq.wake.Wait()
+		}
+		t := tq.ready[0]
+		tq.ready = tq.ready[1:]
+		c := tq.c
+		// Replaced or cancelled since it was handed over
+		if tq.tasks[t.key] != t {
+			tq.mu.Unlock()
+			log.Printf("skipping task %q, it was replaced or cancelled", t.key)
+			tq.pending.Done()
+			continue
+		}
+		tq.mu.Unlock()
+
+		log.Printf("executing task %q", t.key)
+		took, err := tq.call(c, t)
+		if err == errRunning {
+			// Started by hand after it was handed to us
+			tq.requeue(t, false)
+			tq.pending.Done()
+			continue
+		}
 
-			log.Printf("requeuing task %q in %s", k, t.repeat.Truncate(time.Second))
-			t.when = time.Now().Add(t.repeat)
-			if t.when.Before(tq.nextTime) {
-				tq.nextTime = t.when
+		tq.mu.Lock()
+		t.runs++
+		tq.record(t, took, err)
+		tq.results = append(tq.results, result{msg: t.msg, err: err})
+		tq.mu.Unlock()
+		tq.requeue(t, true)
+		tq.pending.Done()
+	}
+}
+
+// Run a task's function, turning panics and timeouts into errors. Returns
+// when the function does, or when it times out. A task that timed out is
+// still marked as running until it returns, so it won't start again before.
+func (tq *taskQueue) call(c *spacetraders.Client, t *task) (time.Duration, error) {
+	tq.mu.Lock()
+	if t.running {
+		tq.mu.Unlock()
+		return 0, errRunning
+	}
+	t.running = true
+	tq.stats.Running++
+	timeout := t.sched.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	tq.mu.Unlock()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("task %q panicked: %v\n%s", t.key, r, debug.Stack())
+				err = fmt.Errorf("task %q panicked: %v", t.key, r)
+				tq.mu.Lock()
+				tq.stats.Panics++
+				tq.mu.Unlock()
 			}
+			tq.mu.Lock()
+			t.running = false
+			tq.stats.Running--
+			tq.mu.Unlock()
+			done <- err
+		}()
+		if t.f != nil {
+			err = t.f(c)
 		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return time.Since(start), err
+	case <-timer.C:
+		log.Printf("task %q timed out after %s", t.key, timeout)
+		tq.mu.Lock()
+		tq.stats.TimedOut++
+		tq.mu.Unlock()
+		return time.Since(start), fmt.Errorf("task %q timed out after %s", t.key, timeout)
 	}
+}
 
-	if len(errs) > 0 {
-		err = fmt.Errorf("%d errors while processing background tasks: %v", len(errs), errs)
+// Keep track of how a run went. tq.mu must be held.
+func (tq *taskQueue) record(t *task, took time.Duration, err error) {
+	t.lastRun = time.Now()
+	t.lastErr = err
+	t.calls++
+	t.lastTook = took
+	t.totalTook += took
+	if took > t.maxTook {
+		t.maxTook = took
+	}
+	tq.stats.Runs++
+	if err != nil {
+		tq.stats.Failed++
 	}
+	tq.notify()
+}
 
-	tq.findNext()
+// How the queue is doing
+func (tq *taskQueue) Stats() Stats {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	s := tq.stats
+	s.Waiting = len(tq.ready)
+	return s
+}
 
-	return msgs, err
+// Put a task back in the queue for its next run, or drop it if it's done.
+// Tasks that were skipped because their condition wasn't met are checked
+// again later, even if they don't repeat.
+func (tq *taskQueue) requeue(t *task, ran bool) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	// Leave it alone if it was replaced or cancelled while running
+	if tq.tasks[t.key] != t {
+		return
+	}
+
+	now := time.Now()
+	next, ok := t.sched.next(now, t.runs)
+	if !ok && !ran && !t.sched.expired(now) {
+		next, ok = now.Add(conditionRecheck), true
+	}
+	if !t.rescheduled.IsZero() {
+		next, ok = t.rescheduled, true
+		t.rescheduled = time.Time{}
+	}
+	if !ok {
+		delete(tq.tasks, t.key)
+		return
+	}
+	log.Printf("requeuing task %q in %s", t.key, next.Sub(now).Truncate(time.Second))
+	t.when = next
+	heap.Push(&tq.queue, t)
+	tq.notify()
 }
 
+// Make a task run no later than when
 func (tq *taskQueue) RunAt(key string, when time.Time) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
 	t, ok := tq.tasks[key]
 	if !ok {
 		return fmt.Errorf("unknown task %q", key)
 	}
 	if t.when.After(when) {
 		t.when = when
+		if t.index >= 0 {
+			heap.Fix(&tq.queue, t.index)
+		}
+		tq.notify()
 	}
 
 	return nil
 }
 
+// Run a task right away, without changing when it's next due
 func (tq *taskQueue) Run(key string) (string, error) {
+	tq.mu.Lock()
 	t, ok := tq.tasks[key]
+	c := tq.c
+	tq.mu.Unlock()
 	if !ok {
 		return "", fmt.Errorf("unknown task %q", key)
 	}
-	var err error
-	if t.f != nil {
-		err = t.f(tq.c)
+	took, err := tq.call(c, t)
+	if err == errRunning {
+		return "", fmt.Errorf("task %q is already running", key)
 	}
+	tq.mu.Lock()
+	tq.record(t, took, err)
+	tq.mu.Unlock()
 
 	return t.msg, err
 }
 
+// Take a task back from the workers if it's waiting for one. tq.mu must be
+// held.
+func (tq *taskQueue) unready(t *task) {
+	for i, r := range tq.ready {
+		if r == t {
+			tq.ready = append(tq.ready[:i], tq.ready[i+1:]...)
+			tq.pending.Done()
+			return
+		}
+	}
+}
+
+// Change when a task is next due, and how often it repeats
+func (tq *taskQueue) Reschedule(key string, when time.Time, repeat time.Duration) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	t, ok := tq.tasks[key]
+	if !ok {
+		return fmt.Errorf("unknown task %q", key)
+	}
+	t.sched.Every = repeat
+	t.sched.Cron = ""
+	if t.index >= 0 {
+		t.when = when
+		heap.Fix(&tq.queue, t.index)
+	} else {
+		// It's running, or about to, apply it when it's requeued
+		t.rescheduled = when
+	}
+	tq.notify()
+
+	return nil
+}
+
+// All the tasks, in the order they're due
+func (tq *taskQueue) List() []TaskInfo {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	var res []TaskInfo
+	for _, t := range tq.tasks {
+		i := TaskInfo{
+			Key:      t.key,
+			Msg:      t.msg,
+			When:     t.when,
+			Schedule: t.sched,
+			Runs:     t.runs,
+			Kind:     t.kind,
+			Args:     t.args,
+			Running:  t.running,
+			LastRun:  t.lastRun,
+			LastTook: t.lastTook,
+			MaxTook:  t.maxTook,
+		}
+		if t.calls > 0 {
+			i.AvgTook = t.totalTook / time.Duration(t.calls)
+		}
+		if t.lastErr != nil {
+			i.LastErr = t.lastErr.Error()
+		}
+		res = append(res, i)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].When.Equal(res[j].When) {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].When.Before(res[j].When)
+	})
+	return res
+}
+
+// Add a task, replacing any existing task with the same key
 func (tq *taskQueue) Add(key, msg string, when time.Time, repeat time.Duration, f func(*spacetraders.Client) error) {
+	tq.add(&task{
+		key:   key,
+		when:  when,
+		sched: Schedule{Every: repeat},
+		msg:   msg,
+		f:     f,
+	})
+}
+
+// Add a task that runs on a schedule, replacing any existing task with the
+// same key. A zero when means the first time the schedule allows.
+func (tq *taskQueue) AddSchedule(key, msg string, when time.Time, s Schedule, f func(*spacetraders.Client) error) error {
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule for %q: %v", key, err)
+	}
+	if when.IsZero() {
+		when = s.first(time.Now())
+	}
+	tq.add(&task{
+		key:   key,
+		when:  when,
+		sched: s,
+		msg:   msg,
+		f:     f,
+	})
+	return nil
+}
+
+// Change how a task repeats. Cron tasks are moved to the next time that
+// matches.
+func (tq *taskQueue) SetSchedule(key string, s Schedule) error {
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule for %q: %v", key, err)
+	}
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
-	log.Printf("Adding task %q at %s (in %s): %q (f: %v)",
-		key, when, when.Sub(time.Now()).Truncate(time.Second), msg, f != nil)
-	if _, ok := tq.tasks[key]; ok {
-		return
+	t, ok := tq.tasks[key]
+	if !ok {
+		return fmt.Errorf("unknown task %q", key)
 	}
-	tq.tasks[key] = &task{
-		when:   when,
-		repeat: repeat,
-		msg:    msg,
-		f:      f,
+	cronChanged := s.Cron != "" && s.Cron != t.sched.Cron
+	t.sched = s
+	if cronChanged && t.index >= 0 {
+		t.when = s.first(time.Now())
+		heap.Fix(&tq.queue, t.index)
+		tq.notify()
+	}
+
+	return nil
+}
+
+func (tq *taskQueue) add(t *task) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	log.Printf("Adding task %q at %s (in %s): %q (f: %v)",
+		t.key, t.when, t.when.Sub(time.Now()).Truncate(time.Second), t.msg, t.f != nil)
+	if old, ok := tq.tasks[t.key]; ok {
+		log.Printf("Replacing task %q", t.key)
+		if old.index >= 0 {
+			heap.Remove(&tq.queue, old.index)
+		}
+		tq.unready(old)
 	}
+	tq.tasks[t.key] = t
+	heap.Push(&tq.queue, t)
+	tq.notify()
+}
 
-	if when.Before(tq.nextTime) {
-		tq.nextTime = when
+// Add a task of a registered kind, replacing any existing task with the same
+// key. Unlike tasks added with Add, these are saved.
+func (tq *taskQueue) AddKind(key, msg string, when time.Time, s Schedule, kind string, args ...string) error {
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule for %q: %v", key, err)
 	}
+	if when.IsZero() {
+		when = s.first(time.Now())
+	}
+	f, err := buildKind(kind, args)
+	if err != nil {
+		return fmt.Errorf("can't create task %q: %v", key, err)
+	}
+	tq.add(&task{
+		key:   key,
+		when:  when,
+		sched: s,
+		msg:   msg,
+		f:     f,
+		kind:  kind,
+		args:  args,
+	})
+
+	return nil
+}
+
+// A task as it's saved
+type savedTask struct {
+	Key      string    `json:"key"`
+	Msg      string    `json:"msg,omitempty"`
+	When     time.Time `json:"when"`
+	Schedule Schedule  `json:"schedule"`
+	Runs     int       `json:"runs,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Args     []string  `json:"args,omitempty"`
 }
 
-func (tq *taskQueue) findNext() {
-	var next time.Time
+// Save the tasks that can be re-created: ones with a kind, and ones that only
+// show a message.
+func (tq *taskQueue) Save() string {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	var saved []savedTask
 	for _, t := range tq.tasks {
-		if next.IsZero() || t.when.Before(next) {
-			next = t.when
+		if t.kind == "" && t.f != nil {
+			continue
+		}
+		saved = append(saved, savedTask{
+			Key:      t.key,
+			Msg:      t.msg,
+			When:     t.when,
+			Schedule: t.sched,
+			Runs:     t.runs,
+			Kind:     t.kind,
+			Args:     t.args,
+		})
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Key < saved[j].Key })
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Can't save tasks: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// Re-create saved tasks. Ones that came due while we weren't running are run
+// right away.
+func (tq *taskQueue) Load(data string) error {
+	var saved []savedTask
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return fmt.Errorf("error decoding tasks: %v", err)
+	}
+
+	now := time.Now()
+	for _, st := range saved {
+		when := st.When
+		if when.Before(now) {
+			log.Printf("Task %q was due at %s, running now", st.Key, when)
+			when = now
+		}
+		if err := st.Schedule.Validate(); err != nil {
+			log.Printf("Skipping saved task %q: %v", st.Key, err)
+			continue
+		}
+		t := &task{
+			key:   st.Key,
+			when:  when,
+			sched: st.Schedule,
+			msg:   st.Msg,
+			runs:  st.Runs,
+			kind:  st.Kind,
+			args:  st.Args,
+		}
+		if st.Kind != "" {
+			f, err := buildKind(st.Kind, st.Args)
+			if err != nil {
+				log.Printf("Skipping saved task %q: %v", st.Key, err)
+				continue
+			}
+			t.f = f
 		}
+		tq.add(t)
 	}
 
-	tq.nextTime = next
+	return nil
+}
+
+// Remove a task, so it won't run again
+func (tq *taskQueue) Cancel(key string) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	t, ok := tq.tasks[key]
+	if !ok {
+		return fmt.Errorf("unknown task %q", key)
+	}
+	delete(tq.tasks, key)
+	if t.index >= 0 {
+		heap.Remove(&tq.queue, t.index)
+	}
+	tq.unready(t)
+	tq.notify()
+
+	return nil
+}
+
+// Run a task as soon as one of the events is published, as well as when it's
+// due. Returns a function that stops watching for the events.
+func (tq *taskQueue) RunOn(key string, types ...spacetraders.EventType) func() {
+	return spacetraders.GetEventBus().Subscribe(func(e spacetraders.Event) {
+		if err := tq.RunAt(key, time.Now()); err != nil {
+			log.Printf("Can't run %q for %s: %v", key, e.Type, err)
+		}
+	}, types...)
 }
 
+// When the next task is due, or zero if there are none
 func (tq *taskQueue) GetNext() time.Time {
-	return tq.nextTime
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	if len(tq.queue) == 0 {
+		return time.Time{}
+	}
+	return tq.queue[0].when
+}
+
+// Returns a channel that fires when the next task is due, or when the queue
+// changes and the next due time needs to be checked again. Calls share a
+// timer, so only the channel from the latest call should be waited on.
+func (tq *taskQueue) Next() <-chan time.Time {
+	tq.mu.Lock()
+	if !tq.timer.Stop() {
+		select {
+		case <-tq.timer.C:
+		default:
+		}
+	}
+	if len(tq.queue) > 0 {
+		tq.timer.Reset(time.Until(tq.queue[0].when))
+	}
+	timer := tq.timer
+	changed := tq.changed
+	tq.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	go func() {
+		select {
+		case t := <-timer.C:
+			ch <- t
+		case <-changed:
+			ch <- time.Now()
+		}
+	}()
+	return ch
 }
@@ -0,0 +1,397 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zigdon/spacetraders"
+)
+
+func TestProcessTasks(t *testing.T) {
+	q := newTaskQueue()
+	now := time.Now()
+	var mu sync.Mutex
+	var ran []string
+	add := func(key string, when time.Time, repeat time.Duration) {
+		q.Add(key, "", when, repeat, func(*spacetraders.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, key)
+			return nil
+		})
+	}
+
+	add("later", now.Add(time.Hour), 0)
+	add("second", now.Add(-time.Second), 0)
+	add("first", now.Add(-time.Minute), 0)
+	add("repeat", now.Add(-time.Millisecond), time.Hour)
+	add("cancelled", now.Add(-time.Hour), 0)
+	add("replaced", now.Add(-time.Hour), 0)
+	add("replaced", now.Add(2*time.Hour), 0)
+	if err := q.Cancel("cancelled"); err != nil {
+		t.Errorf("can't cancel: %v", err)
+	}
+	if err := q.Cancel("cancelled"); err == nil {
+		t.Errorf("cancelled a task twice")
+	}
+
+	if _, err := q.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	q.Wait()
+	// Workers run tasks in parallel, so the order isn't fixed
+	sort.Strings(ran)
+	if diff := cmp.Diff([]string{"first", "repeat", "second"}, ran); diff != "" {
+		t.Errorf("-want +got\n%s", diff)
+	}
+	if _, ok := q.tasks["second"]; ok {
+		t.Errorf("one off task still queued")
+	}
+	if next := q.GetNext(); !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("want next task in an hour, got %s", next.Sub(now))
+	}
+
+	var keys []string
+	for _, i := range q.List() {
+		keys = append(keys, i.Key)
+	}
+	if diff := cmp.Diff([]string{"later", "repeat", "replaced"}, keys); diff != "" {
+		t.Errorf("bad list: -want +got\n%s", diff)
+	}
+
+	if err := q.Reschedule("repeat", now.Add(time.Minute), time.Minute); err != nil {
+		t.Errorf("can't reschedule: %v", err)
+	}
+	if next := q.GetNext(); !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("Reschedule didn't move the task: %s", next.Sub(now))
+	}
+
+	if err := q.RunAt("replaced", now); err != nil {
+		t.Errorf("can't move task: %v", err)
+	}
+	if next := q.GetNext(); !next.Equal(now) {
+		t.Errorf("RunAt didn't move the task: %s", next.Sub(now))
+	}
+}
+
+func TestNext(t *testing.T) {
+	q := newTaskQueue()
+	next := q.Next()
+	q.Add("soon", "", time.Now().Add(10*time.Millisecond), 0, nil)
+	select {
+	case <-next:
+	case <-time.After(time.Second):
+		t.Fatalf("Next didn't fire when a task was added")
+	}
+
+	select {
+	case <-q.Next():
+	case <-time.After(time.Second):
+		t.Fatalf("Next didn't fire when a task was due")
+	}
+	if msgs, err := q.ProcessTasks(); err != nil || len(q.queue) != 0 {
+		t.Errorf("task not run: %v, %v", msgs, err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	kinds["test"] = func(args []string) (func(*spacetraders.Client) error, error) {
+		return func(*spacetraders.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, args...)
+			return nil
+		}, nil
+	}
+	defer delete(kinds, "test")
+
+	now := time.Now()
+	q := newTaskQueue()
+	q.Add("closure", "", now, time.Minute, func(*spacetraders.Client) error { return nil })
+	q.Add("notify", "arrived", now.Add(-time.Minute), 0, nil)
+	if err := q.AddKind("later", "", now.Add(time.Hour), Schedule{}, "test", "a", "b"); err != nil {
+		t.Fatalf("can't add task: %v", err)
+	}
+	if err := q.AddKind("overdue", "", now.Add(-time.Hour), Schedule{Every: time.Hour}, "test", "c"); err != nil {
+		t.Fatalf("can't add task: %v", err)
+	}
+	if err := q.AddKind("bad", "", now, Schedule{}, "unknown"); err == nil {
+		t.Errorf("added a task of an unknown kind")
+	}
+
+	loaded := newTaskQueue()
+	if err := loaded.Load(q.Save()); err != nil {
+		t.Fatalf("can't load tasks: %v", err)
+	}
+
+	var keys []string
+	for _, i := range loaded.List() {
+		keys = append(keys, i.Key)
+		if i.When.Before(now) {
+			t.Errorf("%s: overdue task not moved to now: %s", i.Key, i.When)
+		}
+	}
+	if diff := cmp.Diff([]string{"notify", "overdue", "later"}, keys); diff != "" {
+		t.Errorf("bad tasks loaded: -want +got\n%s", diff)
+	}
+
+	if _, err := loaded.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	loaded.Wait()
+	// Messages come back once the tasks are done
+	msgs, err := loaded.ProcessTasks()
+	if err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	if diff := cmp.Diff([]string{"arrived"}, msgs); diff != "" {
+		t.Errorf("bad messages: -want +got\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"c"}, ran); diff != "" {
+		t.Errorf("bad tasks run: -want +got\n%s", diff)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	credits := 0
+	if err := RegisterValue("testcredits", func() (int, error) { return credits, nil }); err != nil {
+		t.Fatalf("can't register value: %v", err)
+	}
+	defer delete(values, "testcredits")
+
+	q := newTaskQueue()
+	now := time.Now()
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	add := func(key string, s Schedule) {
+		if err := q.AddSchedule(key, "", now.Add(-time.Second), s, func(*spacetraders.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[key]++
+			return nil
+		}); err != nil {
+			t.Fatalf("can't add %s: %v", key, err)
+		}
+	}
+	add("max", Schedule{Every: time.Nanosecond, Max: 2})
+	add("expired", Schedule{Every: time.Hour, Until: now.Add(-time.Minute)})
+	add("rich", Schedule{When: "testcredits>=100"})
+	add("cron", Schedule{Cron: "@hourly", Jitter: time.Minute})
+	if err := q.AddSchedule("bad", "", now, Schedule{When: "unknown>1"}, nil); err == nil {
+		t.Errorf("added a task with an unknown value")
+	}
+
+	run := func() {
+		// Make everything due again
+		q.mu.Lock()
+		for _, t := range q.queue {
+			t.when = now.Add(-time.Second)
+		}
+		q.mu.Unlock()
+		if _, err := q.ProcessTasks(); err != nil {
+			t.Fatalf("error processing tasks: %v", err)
+		}
+		q.Wait()
+	}
+	run()
+	run()
+	credits = 200
+	run()
+	run()
+
+	want := map[string]int{"max": 2, "rich": 1, "cron": 4}
+	if diff := cmp.Diff(want, runs); diff != "" {
+		t.Errorf("bad runs: -want +got\n%s", diff)
+	}
+	var keys []string
+	for _, i := range q.List() {
+		keys = append(keys, i.Key)
+	}
+	if diff := cmp.Diff([]string{"cron"}, keys); diff != "" {
+		t.Errorf("bad tasks left: -want +got\n%s", diff)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	q := newTaskQueue()
+	now := time.Now()
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	q.Add("panics", "", now, 0, func(*spacetraders.Client) error {
+		panic("oops")
+	})
+	q.Add("fails", "failed", now, 0, func(*spacetraders.Client) error {
+		return errors.New("broken")
+	})
+	if err := q.AddSchedule("slow", "", now, Schedule{Every: time.Nanosecond, Timeout: 10 * time.Millisecond},
+		func(*spacetraders.Client) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		}); err != nil {
+		t.Fatalf("can't add task: %v", err)
+	}
+
+	if _, err := q.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	q.Wait()
+	<-started
+
+	// The slow task timed out and is due again, but is still running
+	if _, err := q.Run("slow"); err == nil {
+		t.Errorf("ran a task that's still running")
+	}
+	msgs, err := q.ProcessTasks()
+	if err == nil {
+		t.Errorf("errors from tasks weren't returned")
+	}
+	if diff := cmp.Diff([]string{"failed"}, msgs); diff != "" {
+		t.Errorf("bad messages: -want +got\n%s", diff)
+	}
+	q.Wait()
+	select {
+	case <-started:
+		t.Errorf("slow task started again while running")
+	default:
+	}
+
+	close(release)
+	want := Stats{Workers: workers, Runs: 3, Failed: 3, Panics: 1, TimedOut: 1}
+	for i := 0; i < 100; i++ {
+		if q.Stats().Running == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if diff := cmp.Diff(want, q.Stats()); diff != "" {
+		t.Errorf("bad stats: -want +got\n%s", diff)
+	}
+}
+
+func TestCancelReady(t *testing.T) {
+	q := newTaskQueue()
+	now := time.Now()
+	release := make(chan struct{})
+	started := make(chan struct{}, workers)
+
+	// Keep all the workers busy
+	for i := 0; i < workers; i++ {
+		q.Add(fmt.Sprintf("busy-%d", i), "", now, 0, func(*spacetraders.Client) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+	if _, err := q.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	for i := 0; i < workers; i++ {
+		<-started
+	}
+
+	ran := false
+	q.Add("cancelled", "", now, 0, func(*spacetraders.Client) error {
+		ran = true
+		return nil
+	})
+	if _, err := q.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	if n := q.Stats().Waiting; n != 1 {
+		t.Errorf("want 1 task waiting for a worker, got %d", n)
+	}
+	if err := q.Cancel("cancelled"); err != nil {
+		t.Fatalf("can't cancel: %v", err)
+	}
+	if n := q.Stats().Waiting; n != 0 {
+		t.Errorf("want no tasks waiting after cancelling, got %d", n)
+	}
+
+	close(release)
+	q.Wait()
+	if ran {
+		t.Errorf("cancelled task ran")
+	}
+}
+
+func TestRescheduleRunning(t *testing.T) {
+	q := newTaskQueue()
+	now := time.Now()
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	q.Add("running", "", now, 0, func(*spacetraders.Client) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	if _, err := q.ProcessTasks(); err != nil {
+		t.Fatalf("error processing tasks: %v", err)
+	}
+	<-started
+
+	later := now.Add(time.Hour)
+	if err := q.Reschedule("running", later, 0); err != nil {
+		t.Fatalf("can't reschedule: %v", err)
+	}
+	close(release)
+	q.Wait()
+
+	ts := q.List()
+	if len(ts) != 1 || !ts[0].When.Equal(later) {
+		t.Errorf("want the task due at %s, got %+v", later, ts)
+	}
+}
+
+func TestScheduleWhileProcessing(t *testing.T) {
+	// A condition that takes a while to check, and is never met
+	if err := RegisterValue("testslow", func() (int, error) {
+		time.Sleep(time.Millisecond)
+		return 0, nil
+	}); err != nil {
+		t.Fatalf("can't register value: %v", err)
+	}
+	defer delete(values, "testslow")
+
+	q := newTaskQueue()
+	sched := Schedule{Every: time.Nanosecond, When: "testslow>0"}
+	if err := q.AddSchedule("sched", "", time.Now(), sched, nil); err != nil {
+		t.Fatalf("can't add task: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			sched.Max = i + 1
+			if err := q.SetSchedule("sched", sched); err != nil {
+				t.Errorf("can't set schedule: %v", err)
+			}
+			if err := q.RunAt("sched", time.Now()); err != nil {
+				t.Errorf("can't run task: %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := q.ProcessTasks(); err != nil {
+			t.Fatalf("error processing tasks: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package spacetraders
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxTravelSamples = 50
+	minTravelSamples = 3
+)
+
+type travelSample struct {
+	Distance float64 `json:"distance"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// Predicts flight times per ship speed. Until enough flights are observed,
+// assume two seconds per unit of distance at speed 1, plus half a minute for
+// docking.
+type TravelModel struct {
+	mu      sync.Mutex
+	samples map[int][]travelSample
+}
+
+var travelModel = &TravelModel{samples: make(map[int][]travelSample)}
+
+func GetTravelModel() *TravelModel {
+	return travelModel
+}
+
+func (m *TravelModel) Predict(speed int, dist float64) time.Duration {
+	if speed < 1 {
+		speed = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secs := math.Round(dist*2/float64(speed)) + 30
+	if s := m.samples[speed]; len(s) >= minTravelSamples {
+		var xs, ys []float64
+		for _, t := range s {
+			xs = append(xs, t.Distance)
+			ys = append(ys, t.Seconds)
+		}
+		if a, b, ok := fitLine(xs, ys); ok {
+			secs = math.Max(0, math.Round(a*dist+b))
+		}
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// Record how long a flight took
+func (m *TravelModel) Observe(speed int, dist float64, d time.Duration) {
+	if speed < 1 {
+		speed = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples[speed] = append(m.samples[speed], travelSample{Distance: dist, Seconds: d.Seconds()})
+	if len(m.samples[speed]) > maxTravelSamples {
+		m.samples[speed] = m.samples[speed][len(m.samples[speed])-maxTravelSamples:]
+	}
+}
+
+func (m *TravelModel) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	speeds := []int{}
+	for s := range m.samples {
+		speeds = append(speeds, s)
+	}
+	sort.Ints(speeds)
+	res := []string{"Travel times:"}
+	for _, s := range speeds {
+		res = append(res, fmt.Sprintf("  speed %d: %d flights observed", s, len(m.samples[s])))
+	}
+	return strings.Join(res, "\n")
+}
+
+func (m *TravelModel) Save() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(m.samples)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (m *TravelModel) Load(data string) error {
+	samples := make(map[int][]travelSample)
+	if err := json.Unmarshal([]byte(data), &samples); err != nil {
+		return fmt.Errorf("error decoding travel samples: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = samples
+	return nil
+}
@@ -0,0 +1,52 @@
+package spacetraders
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTravelModel(t *testing.T) {
+	m := &TravelModel{samples: make(map[int][]travelSample)}
+	tests := []struct {
+		desc  string
+		speed int
+		dist  float64
+		want  time.Duration
+	}{
+		{desc: "default", speed: 2, dist: 20, want: 50 * time.Second},
+		{desc: "slower", speed: 1, dist: 20, want: 70 * time.Second},
+		{desc: "speed 0 is speed 1", speed: 0, dist: 20, want: 70 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := m.Predict(tc.speed, tc.dist); got != tc.want {
+			t.Errorf("%s: want %s, got %s", tc.desc, tc.want, got)
+		}
+	}
+
+	// These ships actually take 3 seconds per distance, plus 10
+	actual := func(d float64) time.Duration { return time.Duration(3*d+10) * time.Second }
+	for _, d := range []float64{10, 20, 40} {
+		m.Observe(0, d, actual(d))
+	}
+	for _, d := range []float64{15, 50} {
+		if got := m.Predict(1, d); got != actual(d) {
+			t.Errorf("distance %.0f: want %s, got %s", d, actual(d), got)
+		}
+		if got := m.Predict(0, d); got != actual(d) {
+			t.Errorf("distance %.0f at speed 0: want %s, got %s", d, actual(d), got)
+		}
+	}
+
+	// Other speeds are unaffected
+	if got := m.Predict(2, 20); got != 50*time.Second {
+		t.Errorf("speed 2: want 50s, got %s", got)
+	}
+
+	restored := &TravelModel{}
+	if err := restored.Load(m.Save()); err != nil {
+		t.Fatalf("can't load: %v", err)
+	}
+	if got := restored.Predict(1, 50); got != actual(50) {
+		t.Errorf("after load: want %s, got %s", actual(50), got)
+	}
+}
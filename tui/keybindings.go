@@ -23,6 +23,7 @@ func (t *TUI) keybindings() error {
 		{"", gocui.KeyBackspace2, gocui.ModNone, backspace},
 		{"", gocui.KeyArrowDown, gocui.ModNone, scrollDown},
 		{"", gocui.KeyArrowUp, gocui.ModNone, scrollUp},
+		{"", gocui.KeyEsc, gocui.ModNone, cancel},
 	} {
 		if err := t.g.SetKeybinding(b.view, b.key, b.mod, b.f); err != nil {
 			return err
@@ -62,6 +63,12 @@ func addLine(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+// Stop waiting for flights, as if Cancel was typed
+func cancel(g *gocui.Gui, v *gocui.View) error {
+	lines.AddLine("Cancel")
+	return nil
+}
+
 func scrollDown(g *gocui.Gui, _ *gocui.View) error {
 	return scroll(g, 1)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/awesome-gocui/gocui"
+	"github.com/zigdon/spacetraders"
 )
 
 var prompt = "> "
@@ -67,6 +68,7 @@ func init() {
 			"sidebar": true,
 			"logs":    false,
 			"msgs":    true,
+			"tasks":   false,
 		},
 		initLogs: []string{},
 	}
@@ -149,6 +151,7 @@ func (t *TUI) Toggle(name string) error {
 		}
 		for w := range t.windows {
 			t.windows[w] = !open
+			t.showWindow(w)
 		}
 		return nil
 	}
@@ -156,9 +159,18 @@ func (t *TUI) Toggle(name string) error {
 		return fmt.Errorf("unknown window %q", name)
 	}
 	t.windows[name] = !t.windows[name]
+	t.showWindow(name)
 	return nil
 }
 
+// Update the layout to match whether a window is open
+func (t *TUI) showWindow(name string) {
+	err := mainLayout.definition.HideItem(name, hideLayout(!t.windows[name]))
+	if err != nil && err != NotFound {
+		log.Printf("can't toggle %q: %v", name, err)
+	}
+}
+
 func (t *TUI) PrintMsg(buf, prefix, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	t.g.Update(func(g *gocui.Gui) error {
@@ -184,6 +196,14 @@ func (t *TUI) PrintMsg(buf, prefix, format string, args ...interface{}) {
 	})
 }
 
+// Redraw the views as soon as anything changes, rather than waiting for the
+// heartbeat
+func (t *TUI) Watch(bus *spacetraders.EventBus) func() {
+	return bus.Subscribe(func(spacetraders.Event) {
+		t.g.Update(func(_ *gocui.Gui) error { return nil })
+	})
+}
+
 func (t *TUI) Update(f func(*gocui.Gui) error) {
 	t.g.Update(f)
 }
@@ -15,6 +15,12 @@ var sideBars = &layoutLevel{
 			name:    "sidebar",
 			fUpdate: sidebarUpdate,
 		},
+		{
+			ratio:   1,
+			name:    "tasks",
+			hidden:  layoutHidden,
+			fUpdate: tasksUpdate,
+		},
 		{
 			ratio: 1,
 			name:  "msgs",
@@ -92,6 +98,13 @@ func sidebarUpdate(v *gocui.View) error {
 	return nil
 }
 
+func tasksUpdate(v *gocui.View) error {
+	v.Title = "tasks"
+	v.Clear()
+	fmt.Fprint(v, t.UpdateView("tasks"))
+	return nil
+}
+
 func msgsNew(v *gocui.View) error {
 	v.Autoscroll = true
 	v.Wrap = true